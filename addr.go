@@ -0,0 +1,42 @@
+package pubip
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// GetAddr is like Get but returns a `netip.Addr`, which is comparable and can
+// be a map key.
+func GetAddr(opts ...Option) (netip.Addr, error) {
+	return NewClient(opts...).GetAddr()
+}
+
+// GetAddrBy is like GetIPBy but returns a `netip.Addr`.
+func GetAddrBy(dest string, opts ...Option) (netip.Addr, error) {
+	return NewClient(opts...).GetAddrBy(dest)
+}
+
+// GetAddr is like Get but returns a `netip.Addr`, which is comparable and can
+// be a map key.
+func (c *Client) GetAddr() (netip.Addr, error) {
+	return toAddr(c.Get())
+}
+
+// GetAddrBy is like GetIPBy but returns a `netip.Addr`.
+func (c *Client) GetAddrBy(dest string) (netip.Addr, error) {
+	return toAddr(c.GetIPBy(dest))
+}
+
+// toAddr converts ip to a `netip.Addr`, an IPv4 address never being mapped to
+// IPv6.
+func toAddr(ip net.IP, err error) (netip.Addr, error) {
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}, errors.New("IP address not valid: " + ip.String())
+	}
+	return addr.Unmap(), nil
+}
@@ -0,0 +1,24 @@
+package pubip
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestGetAddr(t *testing.T) {
+	tests := []string{"203.0.113.1", "2001:db8::1", "::ffff:203.0.113.1"}
+	for i, v := range tests {
+		srv := newIPServer(v)
+		expected := netip.MustParseAddr(v).Unmap()
+		addr, err := GetAddrBy(srv.URL)
+		if err != nil || addr != expected {
+			t.Errorf("Error on case %d: %s, %v(actual) != %s(expected)", i, addr, err, expected)
+		}
+		addr, err = GetAddr(WithSources(srv.URL), WithTimeout(50*time.Millisecond))
+		if err != nil || addr != expected {
+			t.Errorf("Error on case %d: %s, %v(actual) != %s(expected)", i, addr, err, expected)
+		}
+		srv.Close()
+	}
+}
@@ -0,0 +1,114 @@
+package pubip
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is the failure of a service skipped because it failed
+// BreakerThreshold times in a row, until BreakerCooldown elapses.
+var ErrCircuitOpen = errors.New("Skipped after consecutive failures")
+
+type circuit struct {
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// circuitKey identifies a service, by its name, along with the settings of
+// the breaker, so that the clients with other settings don't trip or reset
+// the circuits of each other.
+type circuitKey struct {
+	source    string
+	threshold int
+	cooldown  time.Duration
+}
+
+// circuits tracks the failures of the services by their name and the
+// settings of the breaker, so that it's shared by the package level functions
+// and the clients.
+var circuits = struct {
+	sync.Mutex
+	m map[circuitKey]*circuit
+}{m: map[circuitKey]*circuit{}}
+
+func (c *Client) circuitKey(s string) circuitKey {
+	return circuitKey{source: s, threshold: c.BreakerThreshold, cooldown: c.breakerCooldown()}
+}
+
+func (c *Client) breakerCooldown() time.Duration {
+	if c.BreakerCooldown > 0 {
+		return c.BreakerCooldown
+	}
+	return time.Minute
+}
+
+// allow tells whether the service may be queried: it's not failing, or it's
+// been skipped for longer than the cooldown, in which case a single lookup at
+// a time probes it.
+func (c *Client) allow(s string) bool {
+	if c.BreakerThreshold <= 0 {
+		return true
+	}
+	circuits.Lock()
+	defer circuits.Unlock()
+	ci := circuits.m[c.circuitKey(s)]
+	if ci == nil || ci.failures < c.BreakerThreshold {
+		return true
+	}
+	if ci.probing || time.Since(ci.openedAt) < c.breakerCooldown() {
+		return false
+	}
+	ci.probing = true
+	return true
+}
+
+// skipped tells whether the service would be skipped by allow, without
+// probing it.
+func (c *Client) skipped(s string) bool {
+	if c.BreakerThreshold <= 0 {
+		return false
+	}
+	circuits.Lock()
+	defer circuits.Unlock()
+	ci := circuits.m[c.circuitKey(s)]
+	if ci == nil || ci.failures < c.BreakerThreshold {
+		return false
+	}
+	return ci.probing || time.Since(ci.openedAt) < c.breakerCooldown()
+}
+
+// record counts the consecutive failures of the service, and skips it again
+// for the cooldown after a failed probe.
+func (c *Client) record(s string, err error) {
+	if c.BreakerThreshold <= 0 {
+		return
+	}
+	circuits.Lock()
+	defer circuits.Unlock()
+	if err == nil {
+		delete(circuits.m, c.circuitKey(s))
+		return
+	}
+	ci := circuits.m[c.circuitKey(s)]
+	if ci == nil {
+		ci = &circuit{}
+		circuits.m[c.circuitKey(s)] = ci
+	}
+	ci.failures++
+	ci.probing = false
+	if ci.failures >= c.BreakerThreshold {
+		ci.openedAt = time.Now()
+	}
+}
+
+// release lets another lookup probe the service after one was interrupted
+// before it could tell anything.
+func (c *Client) release(s string) {
+	circuits.Lock()
+	defer circuits.Unlock()
+	if ci := circuits.m[c.circuitKey(s)]; ci != nil {
+		ci.probing = false
+	}
+}
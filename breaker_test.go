@@ -0,0 +1,71 @@
+package pubip
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	var down int32 = 1
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer srv.Close()
+
+	c := NewClient(WithSources(srv.URL), WithTimeout(50*time.Millisecond), WithBreaker(2, 300*time.Millisecond))
+	tests := []struct {
+		down  int32
+		sleep time.Duration
+		hits  int32
+		ok    bool
+		err   error
+	}{
+		{1, 0, 1, false, nil},
+		{1, 0, 2, false, nil},
+		// The circuit is open.
+		{1, 0, 2, false, ErrCircuitOpen},
+		{0, 0, 2, false, ErrCircuitOpen},
+		// The cooldown elapsed, the probe succeeds and closes the circuit.
+		{0, 300 * time.Millisecond, 3, true, nil},
+		{1, 0, 4, false, nil},
+	}
+	for i, v := range tests {
+		atomic.StoreInt32(&down, v.down)
+		time.Sleep(v.sleep)
+		_, err := c.Get()
+		if (err == nil) != v.ok {
+			t.Errorf("Error on case %d: unexpected error %v", i, err)
+		}
+		if v.err != nil && !errors.Is(err, v.err) {
+			t.Errorf("Error on case %d: %v(actual) != %v(expected)", i, err, v.err)
+		}
+		if n := atomic.LoadInt32(&hits); n != v.hits {
+			t.Errorf("Error on case %d: %d(actual) != %d(expected) requests", i, n, v.hits)
+		}
+	}
+}
+
+func TestBreakerSettingsAreSeparate(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	strict := NewClient(WithSources(srv.URL), WithTimeout(50*time.Millisecond), WithBreaker(1, time.Minute))
+	lenient := NewClient(WithSources(srv.URL), WithTimeout(50*time.Millisecond), WithBreaker(3, time.Minute))
+	strict.Get()
+	if !strict.skipped(srv.URL) {
+		t.Error("Expected the strict client to skip the service")
+	}
+	if lenient.skipped(srv.URL) {
+		t.Error("Expected the lenient client not to skip the service yet")
+	}
+}
@@ -0,0 +1,79 @@
+package pubip
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	r  Result
+	at time.Time
+	// keep is how long the entry may still be returned, stale or not, after
+	// which it's removed.
+	keep time.Duration
+}
+
+// cacheSweep is how often cache removes the expired entries, on top of the
+// ones cached removes when they are looked up.
+const cacheSweep = time.Minute
+
+// results caches the results of the lookups by the key of their settings, so
+// that it's shared by the package level functions and the clients.
+var results = struct {
+	sync.Mutex
+	m     map[string]cacheEntry
+	swept time.Time
+}{m: map[string]cacheEntry{}}
+
+// cached returns the result cached for key if it's younger than ttl. It
+// removes the entry once it's expired.
+func cached(key string, ttl time.Duration) (Result, bool) {
+	results.Lock()
+	e, ok := results.m[key]
+	if ok && time.Since(e.at) > e.keep {
+		delete(results.m, key)
+		ok = false
+	}
+	results.Unlock()
+	if !ok || time.Since(e.at) > ttl {
+		return Result{}, false
+	}
+	return e.r.clone(), true
+}
+
+// cache caches r for key, to be removed after keep. It also removes the
+// expired entries of the keys which are not looked up anymore, such as the
+// ones of short-lived clients with their own Logger.
+func cache(key string, r Result, keep time.Duration) {
+	results.Lock()
+	defer results.Unlock()
+	now := time.Now()
+	results.m[key] = cacheEntry{r.clone(), now, keep}
+	if now.Sub(results.swept) < cacheSweep {
+		return
+	}
+	results.swept = now
+	for k, e := range results.m {
+		if now.Sub(e.at) > e.keep {
+			delete(results.m, k)
+		}
+	}
+}
+
+// revalidate refreshes the cached result of key in the background, unless
+// it's already being refreshed. The failures are ignored, the stale result
+// being returned until it's too old.
+func (c *Client) revalidate(f Family, key string) {
+	flights.DoChan("revalidate "+key, func() (interface{}, error) {
+		ctx, cancel := c.closable(context.Background())
+		defer cancel()
+		return c.refresh(ctx, f, key)
+	})
+}
+
+// Refresh is like Get but ignores the cache, and updates it with the result.
+func Refresh(opts ...Option) (net.IP, error) {
+	return NewClient(opts...).Refresh()
+}
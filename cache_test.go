@@ -0,0 +1,108 @@
+package pubip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Write([]byte("not an IP"))
+			return
+		}
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer srv.Close()
+
+	c := NewClient(WithSources(srv.URL), WithTimeout(50*time.Millisecond), WithCacheTTL(time.Hour))
+	if _, err := c.Get(); err == nil {
+		t.Fatal("Expected the first lookup to fail")
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Errorf("Expected the error not to be cached and the result to be, got %d requests", n)
+	}
+	a, _ := c.GetDetailed()
+	b, _ := c.GetDetailed()
+	if !a.Timestamp.Equal(b.Timestamp) {
+		t.Errorf("Expected the cached result to keep its timestamp, got %s and %s", a.Timestamp, b.Timestamp)
+	}
+	if _, err := c.Refresh(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Errorf("Expected Refresh to query the service, got %d requests", n)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Write([]byte("203.0.113.1"))
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("203.0.113.2"))
+	}))
+	defer srv.Close()
+
+	c := NewClient(WithSources(srv.URL), WithQuorum(1), WithCacheTTL(50*time.Millisecond), WithStaleWhileRevalidate(time.Hour))
+	if _, err := c.Get(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		ip, err := c.Get()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if ip.String() != "203.0.113.1" {
+			t.Errorf("Expected the stale result while refreshing, got %s", ip)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	ip, err := c.Get()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if ip.String() != "203.0.113.2" {
+		t.Errorf("Expected the refreshed result, got %s", ip)
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Errorf("Expected a single refresh, got %d requests", n)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	cache("evicted on lookup", Result{}, time.Millisecond)
+	cache("evicted by sweep", Result{}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := cached("evicted on lookup", time.Hour); ok {
+		t.Error("Expected the expired entry not to be returned")
+	}
+
+	results.Lock()
+	results.swept = time.Time{}
+	results.Unlock()
+	cache("kept", Result{}, time.Hour)
+	results.Lock()
+	defer results.Unlock()
+	for _, key := range []string{"evicted on lookup", "evicted by sweep"} {
+		if _, ok := results.m[key]; ok {
+			t.Errorf("Expected %q to be removed", key)
+		}
+	}
+	if _, ok := results.m["kept"]; !ok {
+		t.Error(`Expected "kept" to be cached`)
+	}
+}
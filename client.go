@@ -0,0 +1,517 @@
+package pubip
+
+import (
+	"context"
+	"crypto/tls"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/proxy"
+)
+
+// Client queries the services with its own settings, so that lookups with
+// different settings can run concurrently without touching the package level
+// settings. The package level functions use a Client built by NewClient.
+type Client struct {
+	// MaxTries is the maximum amount of tries to attempt to one service. Only
+	// the transient failures are retried, such as timeouts, refused
+	// connections and 429 or 5xx answers.
+	MaxTries int
+	// APIURIs is the URIs of the services answering the IP address as plain
+	// text.
+	APIURIs []string
+	// Sources is the other services, such as the ones answering in JSON or
+	// over DNS, queried along with APIURIs.
+	Sources []Source
+	// BackoffMin is the pause after the first failed try to a service, 100ms
+	// when zero.
+	BackoffMin time.Duration
+	// BackoffMax is the longest pause between tries to a service, 10s when
+	// zero. It also bounds the pauses the services ask for with Retry-After,
+	// which TotalTimeout bounds instead when set.
+	BackoffMax time.Duration
+	// BackoffFactor is what the pause is multiplied by after each failed try,
+	// 2 when zero.
+	BackoffFactor float64
+	// BackoffJitter randomizes the pauses between tries to a service, between
+	// BackoffMin and their value, so that clients don't retry in lockstep.
+	// NewClient sets it; unset, the pauses are deterministic.
+	BackoffJitter bool
+	// DisableBackoff makes the tries to a service follow each other without
+	// any pause, even when the service asks for one with Retry-After.
+	DisableBackoff bool
+	// Quorum is the minimum amount of identical results required from the
+	// services. It is clamped to the amount of services.
+	Quorum int
+	// Consensus decides which address wins among the results. Unanimous by
+	// default.
+	Consensus ConsensusStrategy
+	// MixedFamily decides which address wins when the services answer
+	// addresses of both families to a lookup of any family, unless Validator
+	// is set. PreferIPv4 by default.
+	MixedFamily MixedFamilyPolicy
+	// Validator decides which address wins among the outcomes of the
+	// services instead of Quorum and Consensus when not nil.
+	Validator Validator
+	// Weights is the weight of the services in the Weighted consensus, by
+	// their name, the String of their Source or their URI. It's 1 for the
+	// others.
+	Weights map[string]float64
+	// MinWeight is the minimum total weight of the services answering the
+	// address which wins the Weighted consensus. Quorum is used when zero.
+	MinWeight float64
+	// MaxConcurrency is the maximum amount of services queried at the same
+	// time. Zero means no limit.
+	MaxConcurrency int
+	// SampleSize is the amount of services, picked at random among all of
+	// them, queried by each lookup, so that a large pool spreads the load
+	// instead of querying every service every time. It is raised to Quorum.
+	// Zero queries all the services.
+	SampleSize int
+	// BreakerThreshold is the amount of consecutive failures after which a
+	// service is skipped, until BreakerCooldown elapses and a lookup probes
+	// it again. The failures are counted across all the lookups with the same
+	// BreakerThreshold and BreakerCooldown. Zero never skips any service.
+	BreakerThreshold int
+	// BreakerCooldown is how long a failing service is skipped, one minute
+	// when zero.
+	BreakerCooldown time.Duration
+	// CacheTTL is how long the result of a lookup is reused by the next ones
+	// with the same settings, without querying the services. Errors are
+	// never cached. Zero disables the cache.
+	CacheTTL time.Duration
+	// StaleWhileRevalidate is how long past CacheTTL a cached result is still
+	// returned at once, while a single lookup refreshes the cache in the
+	// background for the next ones. Zero never returns a stale result.
+	StaleWhileRevalidate time.Duration
+	// MinInterval is the minimum time between two lookups querying the
+	// services with the same settings, across the package level functions and
+	// the clients, so that polling doesn't get this machine blocked by the
+	// services. The results served by the cache, and the concurrent lookups
+	// sharing the requests of another one, don't count. Zero means no limit.
+	MinInterval time.Duration
+	// WaitRateLimit makes a lookup started sooner than MinInterval after the
+	// previous one wait for its turn, instead of failing with ErrRateLimited.
+	WaitRateLimit bool
+	// Timeout sets the time limit of collecting results from different
+	// services. The lookup ends sooner once all of them answered or failed,
+	// and a Majority or Weighted consensus once the services which didn't
+	// answer yet cannot change its outcome.
+	Timeout time.Duration
+	// RequestTimeout is the time limit of each request to a service. Zero
+	// means no limit other than the one of HTTPClient.
+	RequestTimeout time.Duration
+	// TotalTimeout is the time limit of querying an HTTP service, all the
+	// tries and the pauses between them included. The failure of the last
+	// try is returned when it elapses. Zero means no limit other than
+	// Timeout, for the lookups querying several services.
+	TotalTimeout time.Duration
+	// MaxRedirects is the maximum amount of redirects followed for a request
+	// to a service. Zero follows none, and the redirect is the answer of the
+	// service.
+	MaxRedirects int
+	// MaxBodySize is the maximum size in bytes of the answer of a service.
+	// MaxBodySize is used when zero.
+	MaxBodySize int64
+	// UserAgent is the User-Agent header sent to the services.
+	UserAgent string
+	// Headers is sent to the services on top of User-Agent, which it may
+	// replace. The values of the ones holding credentials, such as
+	// Authorization, are never logged.
+	Headers http.Header
+	// DialContext connects to the services instead of the transport of
+	// HTTPClient and of LocalAddr, over the network of the family of the
+	// lookup, such as "tcp4" for GetIPv4. The connections of HTTP are not
+	// reused when set.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// LocalAddr is the local address the services are queried from, to
+	// discover the public IP address of a specific interface. Its port is
+	// ignored. The default route of the system is used when nil.
+	LocalAddr net.Addr
+	// FallbackDelay is how long a connection to a service whose name has both
+	// IPv6 and IPv4 addresses waits for IPv6 before racing IPv4 too, so that
+	// a broken IPv6 doesn't stall the lookups (RFC 6555). It has no effect
+	// when the family of the lookup is forced, as by GetIPv4. 300ms when
+	// zero, like the dialer of Go; a negative value disables the race.
+	FallbackDelay time.Duration
+	// SOCKS5 is the address of the SOCKS5 proxy the HTTP services are queried
+	// through, authenticated with SOCKS5Auth when not nil. It replaces the
+	// proxy of the transport of HTTPClient. The services are queried directly
+	// when empty.
+	SOCKS5     string
+	SOCKS5Auth *proxy.Auth
+	// TLSConfig replaces the TLS settings of the transport of HTTPClient, for
+	// instance to trust another CA or to require a newer version. The
+	// certificates are verified against the system pool when nil.
+	TLSConfig *tls.Config
+	// MaxIdleConnsPerHost is the maximum amount of idle connections the
+	// transport of HTTPClient keeps to each service, so that the next lookups
+	// reuse them. The one of the transport is used when zero.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long the transport of HTTPClient keeps an idle
+	// connection. The one of the transport is used when zero.
+	IdleConnTimeout time.Duration
+	// DialTimeout is the time limit of connecting to a service, so that an
+	// unreachable one fails fast. The one of the transport of HTTPClient is
+	// used when zero.
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout is the time limit of the TLS handshake with an HTTPS
+	// service. The one of the transport of HTTPClient is used when zero.
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout is the time limit of waiting for the headers of
+	// the answer of an HTTP service once the request is sent, which a slow
+	// service may need longer than connecting. The one of the transport of
+	// HTTPClient is used when zero.
+	ResponseHeaderTimeout time.Duration
+	// DisableHTTP2 makes the transport of HTTPClient only speak HTTP/1.1 to
+	// the services. HTTP/2 is negotiated over TLS otherwise.
+	DisableHTTP2 bool
+	// LenientParsing accepts the answers of the services holding other words
+	// than the IP address, such as a comment appended by a proxy, taking the
+	// first word which is an IP address. The whole answer must be the address
+	// otherwise.
+	LenientParsing bool
+	// ExpectedNetworks is the networks the address agreed on by the services
+	// must belong to, such as the block of addresses of the ISP, or else the
+	// lookup fails with an *OutsideRangeError. The lookups without a
+	// consensus, such as GetFirst and GetIPBy, check the address they
+	// return. Any address is accepted when empty.
+	ExpectedNetworks []*net.IPNet
+	// AllowPrivate accepts answers which are not public addresses, such as
+	// private, loopback or link-local ones, for services on internal
+	// networks. They are rejected by default.
+	AllowPrivate bool
+	// StablePolls is the amount of consecutive lookups of Watch and OnChange
+	// which must find a new address before it is reported, so that a network
+	// flapping between two addresses doesn't report each flap. The lookups
+	// which fail don't count. The first address found, and a new one when
+	// zero or one, are reported at once.
+	StablePolls int
+	// WatchNetwork makes Watch and OnChange look up as soon as an interface of
+	// this machine gains or loses an address or the routes change, such as
+	// when a laptop roams to another network, bypassing the cache, instead of
+	// only every interval. The changes are notified by netlink on Linux, and
+	// found by comparing the addresses of the interfaces every few seconds on
+	// the other systems.
+	WatchNetwork bool
+	// Logger receives the events of the lookups, such as the failures of the
+	// services. Nothing is logged when nil.
+	Logger Logger
+	// Metrics receives the measurements of the lookups. Nothing is measured
+	// when nil.
+	Metrics Metrics
+	// HTTPClient is the client used to query the services. HTTPClient is used
+	// when nil.
+	HTTPClient *http.Client
+
+	// networksErr is the failure of WithExpectedCIDRs to parse a CIDR,
+	// returned by Validate and the lookups.
+	networksErr error
+
+	// closer is set by NewClient, so that Close stops the watchers of the
+	// Client and of its copies.
+	closer *closer
+}
+
+// closer is closed once, by Close.
+type closer struct {
+	once sync.Once
+	done chan struct{}
+}
+
+// NewClient returns a Client populated with the current package level
+// settings, then configured by opts.
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		MaxTries:       MaxTries,
+		BackoffJitter:  true,
+		APIURIs:        append([]string(nil), APIURIs...),
+		Sources:        append([]Source(nil), Sources...),
+		Quorum:         Quorum,
+		Timeout:        Timeout,
+		RequestTimeout: RequestTimeout,
+		MaxRedirects:   MaxRedirects,
+		MaxBodySize:    MaxBodySize,
+		UserAgent:      UserAgent,
+		HTTPClient:     HTTPClient,
+		closer:         &closer{done: make(chan struct{})},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Close closes the idle connections of HTTPClient and of the transports
+// derived from it, and stops the watchers started by Watch and OnChange. The
+// transports derived for its own HTTPClient or TLSConfig, which no other
+// client shares, are released. The Client should not be used after Close.
+func (c *Client) Close() {
+	hc := c.httpClient()
+	hc.CloseIdleConnections()
+	dialClients.Range(func(k, dc interface{}) bool {
+		key := k.(dialClientKey)
+		if key.c == hc {
+			dc.(*http.Client).CloseIdleConnections()
+		}
+		if (key.c == hc && hc != HTTPClient) || (c.TLSConfig != nil && key.tls == c.TLSConfig) {
+			dialClients.Delete(k)
+		}
+		return true
+	})
+	if c.closer != nil {
+		c.closer.once.Do(func() { close(c.closer.done) })
+	}
+}
+
+// closable returns a copy of ctx which is also done once the Client is closed.
+func (c *Client) closable(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if c.closer != nil {
+		go func() {
+			select {
+			case <-c.closer.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return HTTPClient
+}
+
+// maxBodySize returns MaxBodySize, or the package level one when not
+// positive, clamped to zero.
+func (c *Client) maxBodySize() int64 {
+	n := MaxBodySize
+	if c.MaxBodySize > 0 {
+		n = c.MaxBodySize
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// quorum returns the effective Quorum, which never exceeds the amount of
+// services. The duplicates count, so that they cannot lower it.
+func (c *Client) quorum() int {
+	if n := len(c.APIURIs) + len(c.Sources); c.Quorum > n {
+		return n
+	}
+	return c.Quorum
+}
+
+// sources returns the services to query: APIURIs followed by Sources, without
+// the duplicates, which would answer as several agreeing services.
+func (c *Client) sources() []Source {
+	srcs := make([]Source, 0, len(c.APIURIs)+len(c.Sources))
+	seen := map[string]bool{}
+	add := func(s Source) {
+		if k := sourceKey(s); !seen[k] {
+			seen[k] = true
+			srcs = append(srcs, s)
+		}
+	}
+	for _, u := range c.APIURIs {
+		add(HTTPSource{URL: u})
+	}
+	for _, s := range c.Sources {
+		add(s)
+	}
+	return srcs
+}
+
+// sample returns SampleSize services picked at random, in a random order, or
+// all of them when SampleSize is zero or not less than their amount.
+func (c *Client) sample() []Source {
+	srcs := c.sources()
+	n := c.sampleSize(len(srcs))
+	if n == 0 {
+		return srcs
+	}
+	rand.Shuffle(len(srcs), func(i, j int) { srcs[i], srcs[j] = srcs[j], srcs[i] })
+	return srcs[:n]
+}
+
+// sampleSize returns the amount of services picked at random among total,
+// SampleSize raised to the quorum, or zero when all of them are queried.
+func (c *Client) sampleSize(total int) int {
+	n := c.SampleSize
+	if q := c.quorum(); n < q {
+		n = q
+	}
+	if c.SampleSize <= 0 || n >= total {
+		return 0
+	}
+	return n
+}
+
+// Validate returns an error if a service of APIURIs, or an HTTPSource of
+// Sources, doesn't have an absolute HTTP or HTTPS URI. The lookups still
+// query the other services otherwise. It also returns the failure of
+// WithExpectedCIDRs to parse a CIDR, with which the lookups fail.
+func (c *Client) Validate() error {
+	if c.networksErr != nil {
+		return c.networksErr
+	}
+	for _, s := range c.sources() {
+		if hs, ok := s.(HTTPSource); ok {
+			if err := checkURL(hs.URL); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ActiveSources returns the names of the services the next lookup queries,
+// APIURIs followed by the String of Sources, without the ones skipped by the
+// circuit breaker for now, and without the HTTP services whose URI is not
+// valid, which Validate reports and which can only fail.
+func (c *Client) ActiveSources() []string {
+	var names []string
+	for _, s := range c.sources() {
+		if hs, ok := s.(HTTPSource); ok && checkURL(hs.URL) != nil {
+			continue
+		}
+		if !c.skipped(s.String()) {
+			names = append(names, s.String())
+		}
+	}
+	return names
+}
+
+// GetIPBy queries an API to retrieve a `net.IP` of this machine's public IP
+// address, without any consensus. The request goes through the transport of
+// the client, with its headers, timeouts, retries and backoff.
+func (c *Client) GetIPBy(dest string) (net.IP, error) {
+	return c.GetIPByContext(context.Background(), dest)
+}
+
+// GetIPByContext is like GetIPBy but carries a context. The context is
+// attached to every request and also interrupts the backoff between retries,
+// in which case ctx.Err() is returned.
+func (c *Client) GetIPByContext(ctx context.Context, dest string) (net.IP, error) {
+	r, err := c.getIPByDetailed(ctx, dest)
+	return r.IP, err
+}
+
+// GetIPByDetailed is like GetIPBy but also tells how long the service took to
+// answer and how many retries it needed.
+func (c *Client) GetIPByDetailed(dest string) (Result, error) {
+	return c.getIPByDetailed(context.Background(), dest)
+}
+
+// getIPByDetailed queries the service of dest, and checks its answer belongs
+// to ExpectedNetworks.
+func (c *Client) getIPByDetailed(ctx context.Context, dest string) (Result, error) {
+	if c.networksErr != nil {
+		return Result{}, c.networksErr
+	}
+	start := time.Now()
+	ip, retries, err := c.lookup(ctx, HTTPSource{URL: dest}, anyFamily)
+	if err != nil {
+		return Result{}, err
+	}
+	if err := c.inRange(ip); err != nil {
+		return Result{}, err
+	}
+	now := time.Now()
+	return Result{IP: ip, Source: dest, Duration: now.Sub(start), Timestamp: now, Retries: retries}, nil
+}
+
+// Get queries several APIs to retrieve a `net.IP` of this machine's public IP
+// address.
+func (c *Client) Get() (net.IP, error) {
+	return c.GetContext(context.Background())
+}
+
+// GetContext is like Get but carries a context. If the context is done before
+// the results are collected, ctx.Err() is returned. Concurrent lookups with
+// the same settings share the same requests, which are canceled once the
+// lookup ends.
+func (c *Client) GetContext(ctx context.Context) (net.IP, error) {
+	r, err := c.get(ctx, anyFamily)
+	return r.IP, err
+}
+
+// Refresh is like Get but ignores the cache, and updates it with the result.
+func (c *Client) Refresh() (net.IP, error) {
+	r, err := c.refresh(context.Background(), anyFamily, c.flightKey(anyFamily))
+	return r.IP, err
+}
+
+// GetWithResults is like GetStr but waits for every service to answer, or for
+// Timeout, even once they agreed on an address. It also returns the outcome of
+// each of them, in the order they answered or failed.
+func (c *Client) GetWithResults() ([]SourceResult, string, error) {
+	ctx, span := startSpan(context.Background(), "pubip.Get", attribute.String("pubip.family", anyFamily.String()), attribute.Int("pubip.quorum", c.quorum()))
+	start := time.Now()
+	rs, errs := c.collect(ctx, anyFamily)
+	srs := c.sourceResults(rs, errs)
+	r, err := c.conclude(span, start, rs, errs, anyFamily)
+	if err != nil {
+		return srs, "", err
+	}
+	return srs, r.IP.String(), nil
+}
+
+// GetDetailed is like Get but also tells which service answered and how long
+// it took.
+func (c *Client) GetDetailed() (Result, error) {
+	return c.get(context.Background(), anyFamily)
+}
+
+// GetWithDissent is like GetStr but also returns the services which answered
+// another address of the same family, ignored by the consensus, and their
+// answer. Like GetWithResults, it waits for every service to answer, or for
+// Timeout, even once they agreed on an address, so that no dissent is missed.
+func (c *Client) GetWithDissent() (ip string, dissent map[string]string, err error) {
+	ctx, span := startSpan(context.Background(), "pubip.Get", attribute.String("pubip.family", anyFamily.String()), attribute.Int("pubip.quorum", c.quorum()))
+	start := time.Now()
+	rs, errs := c.collect(ctx, anyFamily)
+	r, err := c.conclude(span, start, rs, errs, anyFamily)
+	if err != nil {
+		return "", nil, err
+	}
+	return r.IP.String(), r.Dissent, nil
+}
+
+// GetAll queries several APIs and reports the answer of each of them, without
+// any consensus. The answers outside ExpectedNetworks are reported as
+// failures.
+func (c *Client) GetAll() (map[string]net.IP, map[string]error) {
+	return c.getAll(context.Background(), anyFamily)
+}
+
+// HealthCheck queries every service once, without any retry, and reports the
+// failure of each of them, nil for the ones answering a valid IP address. The
+// services which didn't answer before ctx is done or Timeout fail with the
+// error of the context.
+func (c *Client) HealthCheck(ctx context.Context) map[string]error {
+	once := *c
+	once.MaxTries = 1
+	ips, errs := once.getAll(ctx, anyFamily)
+	for s := range ips {
+		errs[s] = nil
+	}
+	return errs
+}
+
+// GetFirst queries several APIs and returns the first valid answer, without
+// waiting for a consensus.
+func (c *Client) GetFirst() (net.IP, error) {
+	r, err := c.getFirst(context.Background(), anyFamily)
+	return r.IP, err
+}
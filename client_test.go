@@ -0,0 +1,419 @@
+package pubip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newIPServer starts a server which answers every request with body.
+func newIPServer(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+}
+
+func TestClientsAreIndependent(t *testing.T) {
+	tests := []string{"203.0.113.1", "203.0.113.2"}
+	var wg sync.WaitGroup
+	for i, v := range tests {
+		srv := newIPServer(v)
+		defer srv.Close()
+
+		c := NewClient()
+		c.APIURIs = []string{srv.URL}
+		c.Timeout = 100 * time.Millisecond
+
+		wg.Add(1)
+		go func(i int, expected net.IP) {
+			defer wg.Done()
+			actual, err := c.Get()
+			if err != nil {
+				t.Errorf("Error on case %d: %s", i, err)
+				return
+			}
+			if !actual.Equal(expected) {
+				t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, actual, expected)
+			}
+		}(i, net.ParseIP(v))
+	}
+	wg.Wait()
+}
+
+func TestConcurrentMaxTries(t *testing.T) {
+	var mu sync.Mutex
+	tries := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		tries[r.URL.Path]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	expected := map[string]int{"/1": 1, "/2": 2, "/5": 5}
+	var wg sync.WaitGroup
+	for path, n := range expected {
+		wg.Add(1)
+		go func(path string, n int) {
+			defer wg.Done()
+			GetIPBy(srv.URL+path, WithMaxTries(n), WithDisableBackoff(true))
+		}(path, n)
+	}
+	wg.Wait()
+	if !reflect.DeepEqual(tries, expected) {
+		t.Errorf("%v(actual) != %v(expected)", tries, expected)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	srv := newIPServer("203.0.113.1")
+	defer srv.Close()
+	uris := append([]string(nil), APIURIs...)
+
+	ip, err := Get(WithSources(srv.URL, srv.URL+"/other"), WithQuorum(2), WithTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := net.ParseIP("203.0.113.1"); !ip.Equal(expected) {
+		t.Errorf("%s(actual) != %s(expected)", ip, expected)
+	}
+	if !reflect.DeepEqual(APIURIs, uris) || Quorum != 3 || Timeout != 2*time.Second {
+		t.Error("Options changed the package level settings")
+	}
+}
+
+func TestClientGetIPBy(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" || r.UserAgent() != "probe/1.0" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if atomic.AddInt32(&hits, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer srv.Close()
+
+	c := NewClient(
+		WithHeader("X-Token", "secret"),
+		WithUserAgent("probe/1.0"),
+		WithMaxTries(3),
+		WithBackoff(time.Millisecond, time.Millisecond, 1),
+	)
+	ip, err := c.GetIPBy(srv.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := net.ParseIP("203.0.113.1"); !ip.Equal(expected) {
+		t.Errorf("%s(actual) != %s(expected)", ip, expected)
+	}
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Errorf("Expected the retries of the client, got %d requests", n)
+	}
+}
+
+func TestGetDetailed(t *testing.T) {
+	srv := newIPServer("203.0.113.1")
+	defer srv.Close()
+
+	start := time.Now()
+	r, err := GetDetailed(WithSources(srv.URL), WithTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if r.Timestamp.Sub(start) < r.Duration || r.Timestamp.After(time.Now()) {
+		t.Errorf("Expected the time of the answer, got %s for a lookup started at %s", r.Timestamp, start)
+	}
+	if expected := net.ParseIP("203.0.113.1"); !r.IP.Equal(expected) {
+		t.Errorf("%s(actual) != %s(expected)", r.IP, expected)
+	}
+	if r.Source != srv.URL {
+		t.Errorf("%s(actual) != %s(expected)", r.Source, srv.URL)
+	}
+	if r.Duration <= 0 {
+		t.Errorf("Expected a positive duration, got %s", r.Duration)
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	a := newIPServer("203.0.113.1")
+	defer a.Close()
+	b := newIPServer("203.0.113.2")
+	defer b.Close()
+	bad := newIPServer("not an IP")
+	defer bad.Close()
+
+	ips, errs := GetAll(WithSources(a.URL, b.URL, bad.URL), WithTimeout(time.Second))
+	expected := map[string]net.IP{
+		a.URL: net.ParseIP("203.0.113.1"),
+		b.URL: net.ParseIP("203.0.113.2"),
+	}
+	if !reflect.DeepEqual(ips, expected) {
+		t.Errorf("%s(actual) != %s(expected)", ips, expected)
+	}
+	if len(errs) != 1 || errs[bad.URL] == nil {
+		t.Errorf("Expected a single error from %s, got %s", bad.URL, errs)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	var hits int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	good := newIPServer("203.0.113.1")
+	defer good.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	errs := HealthCheck(ctx, WithSources(good.URL, bad.URL, slow.URL))
+	if err, ok := errs[good.URL]; !ok || err != nil {
+		t.Errorf("Expected %s to be healthy, got %v", good.URL, err)
+	}
+	if errs[bad.URL] == nil || atomic.LoadInt32(&hits) != 1 {
+		t.Errorf("Expected a single failed try of %s, got %v after %d", bad.URL, errs[bad.URL], hits)
+	}
+	if !errors.Is(errs[slow.URL], context.DeadlineExceeded) {
+		t.Errorf("%v(actual) != %v(expected)", errs[slow.URL], context.DeadlineExceeded)
+	}
+}
+
+func TestGetFirst(t *testing.T) {
+	fast := newIPServer("203.0.113.1")
+	defer fast.Close()
+	canceled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(canceled)
+	}))
+	defer slow.Close()
+
+	ip, err := GetFirst(WithSources(slow.URL, fast.URL), WithTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := net.ParseIP("203.0.113.1"); !ip.Equal(expected) {
+		t.Errorf("%s(actual) != %s(expected)", ip, expected)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("Expected the slow request to be canceled")
+	}
+
+	bad := newIPServer("not an IP")
+	defer bad.Close()
+	if _, err := GetFirst(WithSources(bad.URL, bad.URL), WithTimeout(time.Second)); err == nil {
+		t.Error("Expected an error when every service fails")
+	}
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, max := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > max {
+			max = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer srv.Close()
+
+	uris := []string{srv.URL, srv.URL, srv.URL, srv.URL, srv.URL, srv.URL}
+	ips, errs := GetAll(WithSources(uris...), WithMaxConcurrency(2), WithTimeout(time.Second))
+	if len(errs) != 0 {
+		t.Fatalf("Unexpected errors: %s", errs)
+	}
+	if len(ips) != 1 {
+		t.Errorf("Expected a single answer per URI, got %s", ips)
+	}
+	if max > 2 {
+		t.Errorf("%d requests in flight despite a limit of 2", max)
+	}
+}
+
+func TestClose(t *testing.T) {
+	var closed int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("203.0.113.1"))
+	}))
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			atomic.AddInt32(&closed, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	c := NewClient(
+		WithSources(srv.URL),
+		WithHTTPClient(&http.Client{Transport: newTransport()}),
+		WithTimeout(100*time.Millisecond),
+	)
+	ips, errs := c.Watch(context.Background(), 10*time.Millisecond)
+	select {
+	case <-ips:
+	case err := <-errs:
+		t.Fatalf("Unexpected error: %s", err)
+	case <-time.After(time.Second):
+		t.Fatal("Nothing received")
+	}
+
+	c.Close()
+	for ips != nil || errs != nil {
+		select {
+		case _, ok := <-ips:
+			if !ok {
+				ips = nil
+			}
+		case _, ok := <-errs:
+			if !ok {
+				errs = nil
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected the channels to be closed")
+		}
+	}
+	// The watcher may have been in the middle of a lookup.
+	c.Close()
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&closed) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the idle connections to be closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestActiveSources(t *testing.T) {
+	good := newIPServer("203.0.113.1")
+	defer good.Close()
+	bad := httptest.NewServer(http.NotFoundHandler())
+	defer bad.Close()
+
+	c := NewClient(
+		WithSources(good.URL, "not a URL", bad.URL, "ftp://example.com"),
+		WithExtraSources(staticSource("203.0.113.1"), HTTPSource{URL: "://missing-scheme"}),
+		WithBreaker(1, time.Minute),
+		WithTimeout(50*time.Millisecond),
+	)
+	expected := []string{good.URL, bad.URL, "static://203.0.113.1"}
+	if actual := c.ActiveSources(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%v(actual) != %v(expected)", actual, expected)
+	}
+	c.Get()
+	expected = []string{good.URL, "static://203.0.113.1"}
+	if actual := c.ActiveSources(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%v(actual) != %v(expected)", actual, expected)
+	}
+}
+
+func TestDuplicateSources(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer srv.Close()
+
+	dup := strings.Replace(srv.URL, "http://", "HTTP://", 1) + "/#copy"
+	tests := [][]string{
+		{srv.URL, srv.URL},
+		{srv.URL, dup},
+	}
+	for i, v := range tests {
+		atomic.StoreInt32(&hits, 0)
+		if _, err := Get(WithSources(v...), WithQuorum(2), WithTimeout(100*time.Millisecond)); err == nil {
+			t.Errorf("Error on case %d: expected a single server not to satisfy a quorum of 2", i)
+		}
+		if n := atomic.LoadInt32(&hits); n != 1 {
+			t.Errorf("Error on case %d: %d(actual) != 1(expected) requests", i, n)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		uris []string
+		srcs []Source
+		err  string
+	}{
+		{[]string{"https://api.ipify.org", "http://ident.me"}, []Source{OpenDNS}, ""},
+		{[]string{"https://api.ipify.org", "ident.me"}, nil, `"ident.me" is not an HTTP URL`},
+		{nil, []Source{HTTPSource{URL: "ftp://example.com"}}, `"ftp://example.com" is not an HTTP URL`},
+	}
+	for i, v := range tests {
+		c := NewClient(WithSources(v.uris...), WithExtraSources(v.srcs...))
+		err := c.Validate()
+		if actual := fmt.Sprint(err); (err != nil || v.err != "") && actual != v.err {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, actual, v.err)
+		}
+	}
+}
+
+func TestSampleSize(t *testing.T) {
+	tests := []struct {
+		size     int
+		expected int32
+	}{
+		{0, 6},
+		{3, 3},
+		{1, 2},
+	}
+	for i, v := range tests {
+		hits := make([]int32, 6)
+		var uris []string
+		for j := range hits {
+			j := j
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits[j], 1)
+				w.Write([]byte("203.0.113.1"))
+			}))
+			defer srv.Close()
+			uris = append(uris, srv.URL)
+		}
+		c := NewClient(WithSources(uris...), WithQuorum(2), WithSampleSize(v.size), WithTimeout(time.Second))
+		const lookups = 50
+		for n := 0; n < lookups; n++ {
+			if _, err := c.Get(); err != nil {
+				t.Fatalf("Error on case %d: unexpected error %s", i, err)
+			}
+		}
+		var total int32
+		for j := range hits {
+			n := atomic.LoadInt32(&hits[j])
+			if n == 0 {
+				t.Errorf("Error on case %d: service %d never queried", i, j)
+			}
+			total += n
+		}
+		if total != lookups*v.expected {
+			t.Errorf("Error on case %d: %d(actual) != %d(expected) requests", i, total, lookups*v.expected)
+		}
+	}
+}
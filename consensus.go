@@ -0,0 +1,395 @@
+package pubip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ConsensusStrategy decides which address wins among the results of the
+// services.
+type ConsensusStrategy int
+
+const (
+	// Unanimous requires all the results to be identical.
+	Unanimous ConsensusStrategy = iota
+	// Majority requires the most frequent result to be answered by more than
+	// half of the services which responded. The others are ignored.
+	Majority
+	// Weighted requires the address with the largest total weight of the
+	// services which answered it to reach MinWeight, instead of a Quorum of
+	// them. The others are ignored.
+	Weighted
+	// StrictUnanimous is like Unanimous, but the results of both families
+	// are compared with each other too: a single service answering another
+	// address than the others, of any family, fails the lookup.
+	StrictUnanimous
+)
+
+func (s ConsensusStrategy) String() string {
+	switch s {
+	case Unanimous:
+		return "unanimous"
+	case Majority:
+		return "majority"
+	case Weighted:
+		return "weighted"
+	case StrictUnanimous:
+		return "strict-unanimous"
+	}
+	return fmt.Sprintf("ConsensusStrategy(%d)", int(s))
+}
+
+// tally counts the results by address, in the order they were first seen.
+func tally(rs []Result) (firsts []Result, counts []int) {
+	index := map[string]int{}
+	for _, r := range rs {
+		k := r.IP.String()
+		i, ok := index[k]
+		if !ok {
+			i = len(firsts)
+			index[k] = i
+			firsts = append(firsts, r)
+			counts = append(counts, 0)
+		}
+		counts[i]++
+	}
+	return firsts, counts
+}
+
+// settled tells whether the results already decide the outcome, whatever the
+// pending services answer. Only Majority and Weighted may be settled: under
+// Unanimous any answer can still dissent, and a Validator is opaque. With any
+// family, only a consensus of the family preferred by MixedFamily settles, and
+// none does when the policy depends on the answers of both families.
+func (c *Client) settled(rs []Result, pending map[string]bool, f Family) bool {
+	if c.Validator != nil || len(pending) == 0 {
+		return false
+	}
+	if f == anyFamily {
+		switch c.MixedFamily {
+		case PreferIPv4:
+			f = IPv4
+		case PreferIPv6:
+			f = IPv6
+		default:
+			return false
+		}
+	}
+	var frs []Result
+	for _, r := range rs {
+		if f.match(r.IP) {
+			frs = append(frs, r)
+		}
+	}
+	if len(frs) == 0 {
+		return false
+	}
+
+	switch c.Consensus {
+	case Majority:
+		if len(frs) < c.quorum() {
+			return false
+		}
+		_, counts := tally(frs)
+		best := 0
+		for _, n := range counts {
+			if n > best {
+				best = n
+			}
+		}
+		return best*2 > len(frs)+len(pending)
+	case Weighted:
+		_, weights := c.weigh(frs)
+		var remaining float64
+		for s := range pending {
+			remaining += c.weight(s)
+		}
+		best, other := 0, 0.0
+		for i := 1; i < len(weights); i++ {
+			if weights[i] > weights[best] {
+				best = i
+			}
+		}
+		for i := range weights {
+			if i != best && weights[i] > other {
+				other = weights[i]
+			}
+		}
+		return weights[best] >= c.minWeight() && weights[best] > other+remaining
+	}
+	return false
+}
+
+// dissent maps the sources of rs which answered another address than ip, of
+// the same family, to their answer. It's nil when there is none.
+func dissent(rs []Result, ip net.IP) map[string]string {
+	f := familyOf(ip)
+	var d map[string]string
+	for _, r := range rs {
+		if !f.match(r.IP) || r.IP.Equal(ip) {
+			continue
+		}
+		if d == nil {
+			d = map[string]string{}
+		}
+		d[r.Source] = r.IP.String()
+	}
+	return d
+}
+
+// majority returns the most frequent result if it was answered by more than
+// half of rs.
+func majority(rs []Result) (Result, error) {
+	firsts, counts := tally(rs)
+	best := 0
+	for i := range counts {
+		if counts[i] > counts[best] {
+			best = i
+		}
+	}
+	if counts[best]*2 <= len(rs) {
+		return Result{}, newDisagreementError("No majority among results", rs)
+	}
+	return firsts[best], nil
+}
+
+func (c *Client) weight(source string) float64 {
+	if w, ok := c.Weights[source]; ok {
+		return w
+	}
+	return 1
+}
+
+func (c *Client) minWeight() float64 {
+	if c.MinWeight > 0 {
+		return c.MinWeight
+	}
+	return float64(c.quorum())
+}
+
+// weigh sums the weights of the results by address, in the order they were
+// first seen.
+func (c *Client) weigh(rs []Result) (firsts []Result, weights []float64) {
+	firsts, _ = tally(rs)
+	weights = make([]float64, len(firsts))
+	for _, r := range rs {
+		for i := range firsts {
+			if firsts[i].IP.Equal(r.IP) {
+				weights[i] += c.weight(r.Source)
+			}
+		}
+	}
+	return firsts, weights
+}
+
+// weighted returns the result with the largest total weight if it reaches
+// MinWeight and no other one has the same.
+func (c *Client) weighted(rs []Result) (Result, error) {
+	firsts, weights := c.weigh(rs)
+	best, tie := 0, false
+	for i := 1; i < len(weights); i++ {
+		switch {
+		case weights[i] > weights[best]:
+			best, tie = i, false
+		case weights[i] == weights[best]:
+			tie = true
+		}
+	}
+	if tie {
+		return Result{}, newDisagreementError("No result outweighs the others", rs)
+	}
+	if min := c.minWeight(); weights[best] < min {
+		return Result{}, insufficient("Weight of %s below %g: %g", firsts[best].IP, min, weights[best])
+	}
+	return firsts[best], nil
+}
+
+// SourceResult is the outcome of the lookup of a service, either an address
+// or a failure.
+type SourceResult struct {
+	// Source is the URI of the service.
+	Source string
+	// IP is the address the service answered, nil if it failed.
+	IP net.IP
+	// Err is the failure of the service, nil if it answered.
+	Err error
+	// StatusCode is the status code of the last HTTP answer of the service,
+	// zero if it's not an HTTP service or it didn't answer.
+	StatusCode int
+	// Duration is the time Source took to answer or to fail, retries
+	// included. It's zero when it wasn't queried.
+	Duration time.Duration
+	// Timestamp is when Source answered. It's zero for a failure.
+	Timestamp time.Time
+	// Retries is the amount of retries Source needed, zero when it answered
+	// at the first try.
+	Retries int
+}
+
+// Validator decides which address wins among the outcomes of the services, or
+// fails if none does. The address it returns must not be nil, and is checked
+// like the answers of the services: it must be of the family of the lookup,
+// and public unless AllowPrivate is set.
+//
+// Usage:
+//
+//		_, trusted, _ := net.ParseCIDR("203.0.113.0/24")
+//		pubip.WithValidator(func(rs []pubip.SourceResult) (net.IP, error) {
+//			for _, r := range rs {
+//				if r.Err == nil && trusted.Contains(r.IP) {
+//					return r.IP, nil
+//				}
+//			}
+//			return nil, errors.New("No trusted address")
+//		})
+type Validator func(results []SourceResult) (net.IP, error)
+
+// sourceResults merges the answers and the failures of the services.
+func (c *Client) sourceResults(rs []Result, errs []SourceError) []SourceResult {
+	isHTTP := map[string]bool{}
+	for _, s := range c.sources() {
+		if _, ok := s.(HTTPSource); ok {
+			isHTTP[s.String()] = true
+		}
+	}
+	srs := make([]SourceResult, 0, len(rs)+len(errs))
+	for _, r := range rs {
+		sr := SourceResult{Source: r.Source, IP: r.IP, Duration: r.Duration, Timestamp: r.Timestamp, Retries: r.Retries}
+		if isHTTP[r.Source] {
+			sr.StatusCode = 200
+		}
+		srs = append(srs, sr)
+	}
+	for _, e := range errs {
+		sr := SourceResult{Source: e.Source, Err: e.Err, Duration: e.Duration, Retries: e.Retries}
+		var statusErr *HTTPStatusError
+		var parseErr *ParseError
+		switch {
+		case errors.As(e.Err, &statusErr):
+			sr.StatusCode = statusErr.StatusCode
+		case errors.As(e.Err, &parseErr) && isHTTP[e.Source]:
+			sr.StatusCode = 200
+		}
+		srs = append(srs, sr)
+	}
+	return srs
+}
+
+// ToleranceMode returns a Validator accepting the most frequent address if at
+// most maxDissent of the services which answered an address of its family
+// disagree with it, such as "N-1 of N" with a maxDissent of 1. It's stricter
+// than Majority but a single flaky service doesn't fail the lookup as under
+// Unanimous. IPv4 is preferred when both families are accepted.
+//
+// Usage:
+//
+//		ip, err := pubip.Get(pubip.WithValidator(pubip.ToleranceMode(1)))
+func ToleranceMode(maxDissent int) Validator {
+	return func(srs []SourceResult) (net.IP, error) {
+		var rs []Result
+		for _, sr := range srs {
+			if sr.Err == nil {
+				rs = append(rs, Result{IP: sr.IP, Source: sr.Source})
+			}
+		}
+		if len(rs) == 0 {
+			return nil, insufficient("Failed to get any result from %d APIs", len(srs))
+		}
+		var err error
+		for _, f := range []Family{IPv4, IPv6} {
+			var frs []Result
+			for _, r := range rs {
+				if f.match(r.IP) {
+					frs = append(frs, r)
+				}
+			}
+			if len(frs) == 0 {
+				continue
+			}
+			firsts, counts := tally(frs)
+			best, tie := 0, false
+			for i := 1; i < len(counts); i++ {
+				switch {
+				case counts[i] > counts[best]:
+					best, tie = i, false
+				case counts[i] == counts[best]:
+					tie = true
+				}
+			}
+			if !tie && len(frs)-counts[best] <= maxDissent {
+				return firsts[best].IP, nil
+			}
+			if err == nil {
+				err = newDisagreementError(fmt.Sprintf("More than %d results dissent", maxDissent), frs)
+			}
+		}
+		return nil, err
+	}
+}
+
+// decide returns the result which wins, if it belongs to ExpectedNetworks.
+func (c *Client) decide(rs []Result, errs []SourceError, f Family) (Result, error) {
+	if c.networksErr != nil {
+		return Result{}, c.networksErr
+	}
+	r, err := c.pick(rs, errs, f)
+	if err != nil {
+		return Result{}, err
+	}
+	if err := c.inRange(r.IP); err != nil {
+		return Result{}, err
+	}
+	return r, nil
+}
+
+// inRange returns the failure of WithExpectedCIDRs to parse a CIDR, or an
+// *OutsideRangeError if ip doesn't belong to ExpectedNetworks.
+func (c *Client) inRange(ip net.IP) error {
+	if c.networksErr != nil {
+		return c.networksErr
+	}
+	if !c.expected(ip) {
+		return &OutsideRangeError{IP: ip, Networks: c.ExpectedNetworks}
+	}
+	return nil
+}
+
+// expected reports whether ip belongs to one of ExpectedNetworks, or whether
+// there are none.
+func (c *Client) expected(ip net.IP) bool {
+	if len(c.ExpectedNetworks) == 0 {
+		return true
+	}
+	for _, n := range c.ExpectedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// pick returns the result which wins with Validator, or with validate when
+// nil.
+func (c *Client) pick(rs []Result, errs []SourceError, f Family) (Result, error) {
+	if c.Validator == nil {
+		return c.validate(rs, f)
+	}
+	ip, err := c.Validator(c.sourceResults(rs, errs))
+	if err != nil {
+		return Result{}, err
+	}
+	if ip == nil {
+		return Result{}, errors.New("No IP address from the validator")
+	}
+	if err := c.check(ip, f); err != nil {
+		return Result{}, err
+	}
+	for _, r := range rs {
+		if r.IP.Equal(ip) {
+			return r, nil
+		}
+	}
+	return Result{IP: ip}, nil
+}
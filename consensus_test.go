@@ -0,0 +1,367 @@
+package pubip
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMajority(t *testing.T) {
+	a, b, c := net.ParseIP("203.0.113.1"), net.ParseIP("203.0.113.2"), net.ParseIP("203.0.113.3")
+	tests := []struct {
+		input    []net.IP
+		expected net.IP
+	}{
+		{[]net.IP{a, a, a}, a},
+		{[]net.IP{a, a, b}, a},
+		{[]net.IP{b, a, a, a, c}, a},
+		{[]net.IP{a, a, b, b}, nil},
+		{[]net.IP{a, b, c}, nil},
+		{[]net.IP{a, a}, nil},
+	}
+	client := NewClient(WithConsensus(Majority))
+	for i, v := range tests {
+		r, _ := client.validate(toResults(v.input), anyFamily)
+		if !r.IP.Equal(v.expected) {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, r.IP, v.expected)
+		}
+	}
+}
+
+func TestStrictUnanimous(t *testing.T) {
+	a, b, v6 := net.ParseIP("203.0.113.1"), net.ParseIP("203.0.113.2"), net.ParseIP("2001:db8::1")
+	tests := []struct {
+		consensus ConsensusStrategy
+		input     []net.IP
+		expected  net.IP
+	}{
+		{Unanimous, []net.IP{a, a, a}, a},
+		{StrictUnanimous, []net.IP{a, a, a}, a},
+		{Unanimous, []net.IP{a, a, a, b}, nil},
+		{StrictUnanimous, []net.IP{a, a, a, b}, nil},
+		{Unanimous, []net.IP{a, a, a, v6}, a},
+		{StrictUnanimous, []net.IP{a, a, a, v6}, nil},
+	}
+	for i, v := range tests {
+		client := NewClient(WithConsensus(v.consensus), WithQuorum(3))
+		r, err := client.validate(toResults(v.input), anyFamily)
+		if !r.IP.Equal(v.expected) {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, r.IP, v.expected)
+		}
+		if v.expected == nil && !errors.Is(err, ErrNoConsensus) {
+			t.Errorf("Error on case %d: expected ErrNoConsensus, got %v", i, err)
+		}
+	}
+
+	var uris []string
+	for _, answer := range []string{"203.0.113.1", "203.0.113.1", "203.0.113.1", "2001:db8::1"} {
+		srv := newIPServer(answer)
+		defer srv.Close()
+		uris = append(uris, srv.URL)
+	}
+	if _, err := Get(WithSources(uris...), WithConsensus(StrictUnanimous), WithTimeout(100*time.Millisecond)); !errors.Is(err, ErrNoConsensus) {
+		t.Errorf("Expected the dissent to fail the lookup, got %v", err)
+	}
+}
+
+func TestMixedFamilyPolicy(t *testing.T) {
+	a, v6 := net.ParseIP("203.0.113.1"), net.ParseIP("2001:db8::1")
+	tests := []struct {
+		policy   MixedFamilyPolicy
+		input    []net.IP
+		expected net.IP
+	}{
+		{PreferIPv4, []net.IP{a, a, v6, v6}, a},
+		{PreferIPv4, []net.IP{a, v6, v6}, v6},
+		{PreferIPv6, []net.IP{a, a, v6, v6}, v6},
+		{PreferIPv6, []net.IP{a, a, v6}, a},
+		{RequireSameFamily, []net.IP{a, a}, a},
+		{RequireSameFamily, []net.IP{v6, v6}, v6},
+		{RequireSameFamily, []net.IP{a, a, v6}, nil},
+		{SplitByFamily, []net.IP{a, a, v6, v6, v6}, v6},
+		{SplitByFamily, []net.IP{a, a, a, v6, v6}, a},
+		{SplitByFamily, []net.IP{a, a, v6, v6}, a},
+	}
+	for i, v := range tests {
+		client := NewClient(WithMixedFamilyPolicy(v.policy), WithQuorum(2))
+		r, err := client.validate(toResults(v.input), anyFamily)
+		if !r.IP.Equal(v.expected) {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, r.IP, v.expected)
+		}
+		if v.expected == nil && !errors.Is(err, ErrNoConsensus) {
+			t.Errorf("Error on case %d: expected ErrNoConsensus, got %v", i, err)
+		}
+	}
+}
+
+func TestGetWithDissent(t *testing.T) {
+	var srvs []string
+	for _, v := range []string{"203.0.113.1", "203.0.113.1", "2001:db8::1"} {
+		srv := newIPServer(v)
+		defer srv.Close()
+		srvs = append(srvs, srv.URL)
+	}
+	// The dissenter answers once the others already settled the majority.
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("203.0.113.2"))
+	}))
+	defer slow.Close()
+	srvs = append(srvs, slow.URL)
+
+	ip, dissent, err := GetWithDissent(WithSources(srvs...), WithConsensus(Majority), WithQuorum(2), WithTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if ip != "203.0.113.1" {
+		t.Errorf("%s(actual) != %s(expected)", ip, "203.0.113.1")
+	}
+	expected := map[string]string{slow.URL: "203.0.113.2"}
+	if !reflect.DeepEqual(dissent, expected) {
+		t.Errorf("%v(actual) != %v(expected)", dissent, expected)
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	a, b := net.ParseIP("203.0.113.1"), net.ParseIP("203.0.113.2")
+	tests := []struct {
+		results  []Result
+		expected net.IP
+	}{
+		// Weights of 1 need a quorum of results.
+		{[]Result{{IP: a, Source: "x"}, {IP: a, Source: "y"}}, nil},
+		{[]Result{{IP: a, Source: "x"}, {IP: a, Source: "y"}, {IP: a, Source: "z"}}, a},
+		// The trusted service is enough alone.
+		{[]Result{{IP: b, Source: "trusted"}}, b},
+		{[]Result{{IP: b, Source: "trusted"}, {IP: a, Source: "x"}, {IP: a, Source: "y"}}, b},
+		{[]Result{{IP: b, Source: "trusted"}, {IP: a, Source: "x"}, {IP: a, Source: "y"}, {IP: a, Source: "z"}}, nil},
+	}
+	client := NewClient(WithConsensus(Weighted), WithWeight("trusted", 3))
+	for i, v := range tests {
+		r, _ := client.validate(v.results, anyFamily)
+		if !r.IP.Equal(v.expected) {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, r.IP, v.expected)
+		}
+	}
+}
+
+func TestValidator(t *testing.T) {
+	trusted := newIPServer("203.0.113.1")
+	defer trusted.Close()
+	other := newIPServer("198.51.100.1")
+	defer other.Close()
+	bad := newIPServer("not an IP")
+	defer bad.Close()
+
+	_, cidr, _ := net.ParseCIDR("203.0.113.0/24")
+	var outcomes int
+	r, err := GetDetailed(WithSources(other.URL, trusted.URL, bad.URL), WithTimeout(100*time.Millisecond), WithValidator(func(rs []SourceResult) (net.IP, error) {
+		outcomes = len(rs)
+		for _, r := range rs {
+			if r.Err == nil && cidr.Contains(r.IP) {
+				return r.IP, nil
+			}
+		}
+		return nil, errors.New("No trusted address")
+	}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if r.Source != trusted.URL || !r.IP.Equal(net.ParseIP("203.0.113.1")) {
+		t.Errorf("%s from %s(actual) != %s from %s(expected)", r.IP, r.Source, "203.0.113.1", trusted.URL)
+	}
+	if outcomes != 3 {
+		t.Errorf("Expected the validator to get the 3 outcomes, got %d", outcomes)
+	}
+
+	// The answer of the validator is checked like the ones of the services.
+	tests := []struct {
+		ip  net.IP
+		get func(c *Client) (net.IP, error)
+	}{
+		{nil, (*Client).Get},
+		{net.ParseIP("192.168.1.1"), (*Client).Get},
+		{net.ParseIP("2001:db8::1"), (*Client).GetIPv4},
+	}
+	for i, v := range tests {
+		c := NewClient(WithSources(trusted.URL), WithTimeout(100*time.Millisecond), WithValidator(func(rs []SourceResult) (net.IP, error) {
+			return v.ip, nil
+		}))
+		if ip, err := v.get(c); err == nil {
+			t.Errorf("Error on case %d: expected the answer of the validator to fail the lookup, got %s", i, ip)
+		}
+	}
+}
+
+func TestSettled(t *testing.T) {
+	var srvs []string
+	for _, v := range []string{"203.0.113.1", "203.0.113.1", "203.0.113.1"} {
+		srv := newIPServer(v)
+		defer srv.Close()
+		srvs = append(srvs, srv.URL)
+	}
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hung.Close()
+	srvs = append(srvs, hung.URL)
+
+	tests := []struct {
+		opts  []Option
+		early bool
+	}{
+		{[]Option{WithConsensus(Majority)}, true},
+		// The hung service could still answer an IPv6 address.
+		{[]Option{WithConsensus(Majority), WithMixedFamilyPolicy(RequireSameFamily)}, false},
+		{[]Option{WithConsensus(Weighted)}, true},
+		{[]Option{WithConsensus(Weighted), WithWeight(hung.URL, 3)}, false},
+		// The hung service could still dissent.
+		{[]Option{WithConsensus(Unanimous)}, false},
+	}
+	for i, v := range tests {
+		opts := append([]Option{WithSources(srvs...), WithTimeout(300 * time.Millisecond)}, v.opts...)
+		start := time.Now()
+		if _, err := Get(opts...); err != nil {
+			t.Errorf("Error on case %d: %s", i, err)
+		}
+		if early := time.Since(start) < 300*time.Millisecond; early != v.early {
+			t.Errorf("Error on case %d: %t(actual) != %t(expected)", i, early, v.early)
+		}
+	}
+}
+
+func TestGetWithResults(t *testing.T) {
+	good := newIPServer("203.0.113.1")
+	defer good.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer slow.Close()
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	srs, ip, err := GetWithResults(
+		WithSources(good.URL, good.URL+"/other", slow.URL, missing.URL),
+		WithExtraSources(staticSource("203.0.113.1")),
+		WithQuorum(2),
+		WithConsensus(Majority),
+		WithTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if ip != "203.0.113.1" {
+		t.Errorf("%s(actual) != 203.0.113.1(expected)", ip)
+	}
+	expected := map[string]int{good.URL: 200, good.URL + "/other": 200, slow.URL: 200, missing.URL: 404, "static://203.0.113.1": 0}
+	if len(srs) != len(expected) {
+		t.Fatalf("Expected a result per source, got %v", srs)
+	}
+	for _, sr := range srs {
+		if code, ok := expected[sr.Source]; !ok || sr.StatusCode != code {
+			t.Errorf("Error on %s: %d(actual) != %d(expected)", sr.Source, sr.StatusCode, code)
+		}
+		if (sr.Err != nil) != (sr.Source == missing.URL) {
+			t.Errorf("Error on %s: unexpected error %v", sr.Source, sr.Err)
+		}
+		if sr.Source == slow.URL && sr.Duration < 100*time.Millisecond {
+			t.Errorf("Error on %s: took %s", sr.Source, sr.Duration)
+		}
+	}
+}
+
+func TestToleranceMode(t *testing.T) {
+	a, b, c := net.ParseIP("203.0.113.1"), net.ParseIP("203.0.113.2"), net.ParseIP("2001:db8::1")
+	tests := []struct {
+		maxDissent int
+		input      []net.IP
+		expected   net.IP
+	}{
+		{1, []net.IP{a, a, a, b}, a},
+		{1, []net.IP{a, a, b, b}, nil},
+		{2, []net.IP{a, a, b, b}, nil},
+		{0, []net.IP{a, a, a, b}, nil},
+		{1, []net.IP{a, b, b, c}, b},
+		{0, []net.IP{a, b, c, c}, c},
+	}
+	for i, v := range tests {
+		var srs []SourceResult
+		for j, ip := range v.input {
+			srs = append(srs, SourceResult{Source: strconv.Itoa(j), IP: ip})
+		}
+		srs = append(srs, SourceResult{Source: "down", Err: errors.New("Timeout")})
+		actual, err := ToleranceMode(v.maxDissent)(srs)
+		if (err != nil) != (v.expected == nil) || !actual.Equal(v.expected) {
+			t.Errorf("Error on case %d: %s, %v(actual) != %s(expected)", i, actual, err, v.expected)
+		}
+	}
+
+	var uris []string
+	for _, answer := range []string{"203.0.113.1", "203.0.113.1", "203.0.113.1", "203.0.113.2"} {
+		srv := newIPServer(answer)
+		defer srv.Close()
+		uris = append(uris, srv.URL)
+	}
+	ip, err := Get(WithSources(uris...), WithValidator(ToleranceMode(1)))
+	if err != nil || !ip.Equal(a) {
+		t.Errorf("%s, %v(actual) != %s(expected)", ip, err, a)
+	}
+}
+
+func TestExpectedCIDRs(t *testing.T) {
+	tests := []struct {
+		cidrs   []string
+		answer  string
+		outside bool
+		invalid bool
+	}{
+		{nil, "198.51.100.9", false, false},
+		{[]string{"203.0.113.0/24"}, "203.0.113.5", false, false},
+		{[]string{"203.0.113.0/24", "2001:db8::/32"}, "2001:db8::1", false, false},
+		{[]string{"203.0.113.0/24"}, "198.51.100.9", true, false},
+		{[]string{"203.0.113.0/24", "203.0.113.0"}, "203.0.113.5", false, true},
+	}
+	for i, v := range tests {
+		srv := newIPServer(v.answer)
+		c := NewClient(WithSources(srv.URL), WithQuorum(1), WithTimeout(100*time.Millisecond), WithExpectedCIDRs(v.cidrs...))
+		verr := c.Validate()
+		if (verr != nil) != v.invalid {
+			t.Errorf("Error on case %d: unexpected validation %v", i, verr)
+		}
+		// The lookups without a consensus check the address too.
+		lookups := map[string]func() (net.IP, error){
+			"Get":      c.Get,
+			"GetFirst": c.GetFirst,
+			"GetIPBy":  func() (net.IP, error) { return c.GetIPBy(srv.URL) },
+		}
+		for name, lookup := range lookups {
+			ip, err := lookup()
+			var rangeErr *OutsideRangeError
+			switch {
+			case v.invalid:
+				if err == nil || errors.As(err, &rangeErr) {
+					t.Errorf("Error on case %d: expected the invalid CIDR to fail %s, got %v", i, name, err)
+				}
+			case v.outside:
+				if !errors.Is(err, ErrOutsideExpectedRange) || !errors.As(err, &rangeErr) || rangeErr.IP.String() != v.answer {
+					t.Errorf("Error on case %d: expected %s outside the range in %s, got %v", i, v.answer, name, err)
+				}
+			case err != nil:
+				t.Errorf("Error on case %d: unexpected error in %s: %s", i, name, err)
+			case ip.String() != v.answer:
+				t.Errorf("Error on case %d: %s(actual) != %s(expected) in %s", i, ip, v.answer, name)
+			}
+		}
+		ips, errs := c.GetAll()
+		if outside := v.outside || v.invalid; (len(ips) == 0) != outside || (errs[srv.URL] != nil) != outside {
+			t.Errorf("Error on case %d: unexpected answers of GetAll %v, %v", i, ips, errs)
+		}
+		srv.Close()
+	}
+}
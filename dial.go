@@ -0,0 +1,267 @@
+package pubip
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// localIP returns the IP address of addr, whatever its kind.
+func localIP(addr net.Addr) (net.IP, string) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP, a.Zone
+	case *net.UDPAddr:
+		return a.IP, a.Zone
+	case *net.IPAddr:
+		return a.IP, a.Zone
+	}
+	return nil, ""
+}
+
+// newDialer returns a dialer for the network which binds its connections to
+// the IP address of local, when not nil.
+func newDialer(network string, local net.Addr) *net.Dialer {
+	d := &net.Dialer{}
+	ip, zone := localIP(local)
+	switch {
+	case ip == nil:
+	case strings.HasPrefix(network, "udp"):
+		d.LocalAddr = &net.UDPAddr{IP: ip, Zone: zone}
+	default:
+		d.LocalAddr = &net.TCPAddr{IP: ip, Zone: zone}
+	}
+	return d
+}
+
+// transportTimeout is the time limit of connecting, of the TLS handshake and
+// of waiting for the headers of the answer of the transport of HTTPClient.
+const transportTimeout = 5 * time.Second
+
+// newTransport returns a transport like http.DefaultTransport, which reads the
+// proxy to use from the environment on every request rather than once, so
+// that it follows the changes of the environment, and with transportTimeout.
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = func(r *http.Request) (*url.URL, error) {
+		return httpproxy.FromEnvironment().ProxyFunc()(r.URL)
+	}
+	t.DialContext = (&net.Dialer{Timeout: transportTimeout, KeepAlive: 30 * time.Second}).DialContext
+	t.TLSHandshakeTimeout = transportTimeout
+	t.ResponseHeaderTimeout = transportTimeout
+	return t
+}
+
+type dialClientKey struct {
+	c        *http.Client
+	f        Family
+	local    string
+	socks5   string
+	auth     proxy.Auth
+	tls      *tls.Config
+	idle     int
+	ttl      time.Duration
+	h1       bool
+	fallback time.Duration
+	dialTO   time.Duration
+	tlsTO    time.Duration
+	headerTO time.Duration
+	unix     string
+}
+
+// dialClients caches the clients derived by dialClient so that their
+// connection pools are reused across lookups. The ones keyed by the
+// HTTPClient or the TLSConfig of a Client are released by its Close.
+var dialClients sync.Map
+
+// dial returns DialContext, or else a dialer binding the connections to
+// LocalAddr when not nil, with FallbackDelay and DialTimeout.
+func (c *Client) dial() dialFunc {
+	if c.DialContext != nil {
+		return c.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		d := newDialer(network, c.LocalAddr)
+		d.FallbackDelay = c.FallbackDelay
+		d.Timeout = c.DialTimeout
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+// dialClient returns a copy of the HTTP client whose transport only dials over
+// the network of the family, with DialContext or from LocalAddr when not nil,
+// through the SOCKS5 proxy when set, with TLSConfig when not nil, and tuned
+// by MaxIdleConnsPerHost, IdleConnTimeout, DisableHTTP2 and the timeouts of
+// connecting, of the TLS handshake and of the response headers. If the transport
+// of the HTTP client is not an `*http.Transport`, it is returned as is and
+// only the check of the family on the results applies.
+func (c *Client) dialClient(f Family) *http.Client {
+	hc := c.httpClient()
+	if f == anyFamily && c.LocalAddr == nil && c.DialContext == nil && c.SOCKS5 == "" && c.TLSConfig == nil &&
+		c.MaxIdleConnsPerHost == 0 && c.IdleConnTimeout == 0 && !c.DisableHTTP2 && c.FallbackDelay == 0 &&
+		c.DialTimeout == 0 && c.TLSHandshakeTimeout == 0 && c.ResponseHeaderTimeout == 0 {
+		return hc
+	}
+	k := dialClientKey{
+		c: hc, f: f, socks5: c.SOCKS5, tls: c.TLSConfig,
+		idle: c.MaxIdleConnsPerHost, ttl: c.IdleConnTimeout, h1: c.DisableHTTP2,
+		fallback: c.FallbackDelay, dialTO: c.DialTimeout, tlsTO: c.TLSHandshakeTimeout,
+		headerTO: c.ResponseHeaderTimeout,
+	}
+	if c.LocalAddr != nil {
+		k.local = c.LocalAddr.String()
+	}
+	if c.SOCKS5Auth != nil {
+		k.auth = *c.SOCKS5Auth
+	}
+	if dc, ok := dialClients.Load(k); ok && c.DialContext == nil {
+		return dc.(*http.Client)
+	}
+
+	var t *http.Transport
+	switch rt := hc.Transport.(type) {
+	case nil:
+		t = newTransport()
+	case *http.Transport:
+		t = rt.Clone()
+	default:
+		return hc
+	}
+	if c.TLSConfig != nil {
+		t.TLSClientConfig = c.TLSConfig.Clone()
+	}
+	if c.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+	if c.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = c.IdleConnTimeout
+	}
+	if c.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = c.TLSHandshakeTimeout
+	}
+	if c.ResponseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = c.ResponseHeaderTimeout
+	}
+	if c.DisableHTTP2 {
+		// A non nil map stops the transport from upgrading to HTTP/2.
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	var dial dialFunc = t.DialContext
+	if dial == nil || c.LocalAddr != nil || c.DialContext != nil || c.FallbackDelay != 0 || c.DialTimeout > 0 {
+		dial = c.dial()
+	}
+	if c.SOCKS5 != "" {
+		t.Proxy = nil
+		t.DialContext = socks5Dial(c.SOCKS5, c.SOCKS5Auth, dial, f)
+	} else {
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dial(ctx, f.network(network), addr)
+		}
+	}
+
+	dc := *hc
+	dc.Transport = t
+	if c.DialContext != nil {
+		// DialContext cannot be part of the key, so the client is not reused,
+		// and doesn't keep its connections alive not to leak them.
+		t.DisableKeepAlives = true
+		return &dc
+	}
+	v, _ := dialClients.LoadOrStore(k, &dc)
+	return v.(*http.Client)
+}
+
+// unixClient returns a copy of the HTTP client whose transport connects to
+// the Unix socket at path, whatever the host of the requests, without any
+// proxy. If the transport of the HTTP client is not an `*http.Transport`, it
+// is returned as is.
+func (c *Client) unixClient(path string) *http.Client {
+	hc := c.httpClient()
+	k := dialClientKey{c: hc, unix: path, dialTO: c.DialTimeout}
+	if uc, ok := dialClients.Load(k); ok {
+		return uc.(*http.Client)
+	}
+	var t *http.Transport
+	switch rt := hc.Transport.(type) {
+	case nil:
+		t = newTransport()
+	case *http.Transport:
+		t = rt.Clone()
+	default:
+		return hc
+	}
+	t.Proxy = nil
+	d := &net.Dialer{Timeout: c.DialTimeout}
+	t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return d.DialContext(ctx, "unix", path)
+	}
+	uc := *hc
+	uc.Transport = t
+	v, _ := dialClients.LoadOrStore(k, &uc)
+	return v.(*http.Client)
+}
+
+// dialFunc is the signature of DialContext, usable as a proxy.Dialer.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (d dialFunc) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+func (d dialFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
+
+// socks5Dial returns a DialContext which connects through the SOCKS5 proxy at
+// addr, reached with forward. Unless f is anyFamily, the host is resolved
+// locally to an address of the family, so that the proxy connects over its
+// network.
+func socks5Dial(addr string, auth *proxy.Auth, forward dialFunc, f Family) dialFunc {
+	d, err := proxy.SOCKS5("tcp", addr, auth, forward)
+	return func(ctx context.Context, network, target string) (net.Conn, error) {
+		if err != nil {
+			return nil, err
+		}
+		if f != anyFamily {
+			host, port, err := net.SplitHostPort(target)
+			if err != nil {
+				return nil, err
+			}
+			ips, err := net.DefaultResolver.LookupIP(ctx, f.network("ip"), host)
+			if err != nil {
+				return nil, err
+			}
+			target = net.JoinHostPort(ips[0].String(), port)
+		}
+		return d.(proxy.ContextDialer).DialContext(ctx, network, target)
+	}
+}
+
+// limitRedirects returns a copy of hc which follows at most MaxRedirects
+// redirects, before applying its own CheckRedirect if any.
+func (c *Client) limitRedirects(hc *http.Client) *http.Client {
+	rc := *hc
+	rc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if c.MaxRedirects <= 0 {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > c.MaxRedirects {
+			return fmt.Errorf("Stopped after %d redirects", c.MaxRedirects)
+		}
+		if hc.CheckRedirect != nil {
+			return hc.CheckRedirect(req, via)
+		}
+		return nil
+	}
+	return &rc
+}
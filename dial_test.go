@@ -0,0 +1,429 @@
+package pubip
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDialClient(t *testing.T) {
+	hc := &http.Client{}
+	c := NewClient(WithHTTPClient(hc))
+	if c.dialClient(anyFamily) != hc {
+		t.Error("Expected the client to be used as is for any family")
+	}
+	fc := c.dialClient(IPv4)
+	if fc == hc || fc.Transport == nil {
+		t.Error("Expected a derived client with its own transport")
+	}
+	if c.dialClient(IPv4) != fc {
+		t.Error("Expected the derived client to be reused")
+	}
+}
+
+func TestNewDialer(t *testing.T) {
+	ip := net.ParseIP("127.0.0.1")
+	tests := []struct {
+		network  string
+		local    net.Addr
+		expected net.Addr
+	}{
+		{"tcp", nil, nil},
+		{"tcp", &net.IPAddr{IP: ip}, &net.TCPAddr{IP: ip}},
+		{"tcp4", &net.TCPAddr{IP: ip, Port: 80}, &net.TCPAddr{IP: ip}},
+		{"udp", &net.TCPAddr{IP: ip}, &net.UDPAddr{IP: ip}},
+	}
+	for i, v := range tests {
+		actual := newDialer(v.network, v.local).LocalAddr
+		if !reflect.DeepEqual(actual, v.expected) {
+			t.Errorf("Error on case %d: %v(actual) != %v(expected)", i, actual, v.expected)
+		}
+	}
+}
+
+func TestLocalAddr(t *testing.T) {
+	var remote string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote, _, _ = net.SplitHostPort(r.RemoteAddr)
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer srv.Close()
+
+	// 127.0.0.2 is a loopback address too, distinct from the one of srv.
+	local := &net.IPAddr{IP: net.ParseIP("127.0.0.2")}
+	if _, err := GetIPBy(srv.URL, WithLocalAddr(local)); err != nil {
+		t.Skipf("Cannot bind to %s: %s", local, err)
+	}
+	if remote != "127.0.0.2" {
+		t.Errorf("%s(actual) != %s(expected)", remote, "127.0.0.2")
+	}
+}
+
+// newSOCKS5Server starts a SOCKS5 proxy without authentication, which sends
+// the address of every connection it relays on the returned channel.
+func newSOCKS5Server(t *testing.T) (net.Listener, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := make(chan string, 16)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				target, err := socks5Handshake(conn)
+				if err != nil {
+					return
+				}
+				targets <- target
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return l, targets
+}
+
+// socks5Handshake reads the greeting and the CONNECT request of a client, and
+// returns the address it asks for.
+func socks5Handshake(conn net.Conn) (string, error) {
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return "", err
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return "", err
+	}
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return "", err
+	}
+	var host string
+	switch buf[3] {
+	case 1, 4:
+		n := net.IPv4len
+		if buf[3] == 4 {
+			n = net.IPv6len
+		}
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return "", err
+		}
+		host = net.IP(buf[:n]).String()
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return "", err
+		}
+		n := int(buf[0])
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return "", err
+		}
+		host = string(buf[:n])
+	default:
+		return "", errors.New("Unknown address type")
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2])))), nil
+}
+
+func TestSOCKS5(t *testing.T) {
+	srv := newIPServer("203.0.113.1")
+	defer srv.Close()
+	l, targets := newSOCKS5Server(t)
+	defer l.Close()
+
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	c := NewClient(WithSOCKS5(l.Addr().String(), nil), WithTimeout(time.Second))
+	tests := []struct {
+		get      func() (net.IP, error)
+		expected string
+	}{
+		{func() (net.IP, error) { return c.GetIPBy(srv.URL) }, srv.Listener.Addr().String()},
+		// The host is resolved locally to force the family.
+		{func() (net.IP, error) {
+			c.APIURIs = []string{"http://localhost:" + port}
+			return c.GetIPv4()
+		}, "127.0.0.1:" + port},
+	}
+	for i, v := range tests {
+		if _, err := v.get(); err != nil {
+			t.Errorf("Error on case %d: %s", i, err)
+			continue
+		}
+		select {
+		case actual := <-targets:
+			if actual != v.expected {
+				t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, actual, v.expected)
+			}
+		default:
+			t.Errorf("Error on case %d: the proxy was not used", i)
+		}
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	var connects []string
+	p := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			connects = append(connects, r.Host)
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer p.Close()
+
+	t.Setenv("HTTPS_PROXY", p.URL)
+	t.Setenv("NO_PROXY", "")
+	if _, err := GetIPBy("https://pubip.invalid/", WithMaxTries(1)); err == nil {
+		t.Error("Expected an error from the proxy")
+	}
+	expected := []string{"pubip.invalid:443"}
+	if !reflect.DeepEqual(connects, expected) {
+		t.Errorf("%v(actual) != %v(expected)", connects, expected)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("203.0.113.1"))
+	}))
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	tests := []struct {
+		config *tls.Config
+		ok     bool
+	}{
+		{nil, false},
+		{&tls.Config{RootCAs: pool}, true},
+		{&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS13}, false},
+	}
+	for i, v := range tests {
+		_, err := GetIPBy(srv.URL, WithTLSConfig(v.config), WithMaxTries(1))
+		if (err == nil) != v.ok {
+			t.Errorf("Error on case %d: %v(actual) != %t(expected)", i, err, v.ok)
+		}
+	}
+}
+
+func TestMaxRedirects(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if r.URL.Path == "/ip" {
+			w.Write([]byte("203.0.113.1"))
+			return
+		}
+		target := r.URL.Path
+		if target == "/to-ip" {
+			target = "/ip"
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path string
+		max  int
+		hits int32
+		ok   bool
+	}{
+		// A redirect loop would happen again, so it is not retried.
+		{"/loop", 3, 4, false},
+		{"/loop", 0, 1, false},
+		{"/to-ip", 3, 2, true},
+		{"/to-ip", 0, 1, false},
+	}
+	for i, v := range tests {
+		atomic.StoreInt32(&hits, 0)
+		_, err := GetIPBy(srv.URL+v.path, WithMaxRedirects(v.max), WithDisableBackoff(true))
+		if (err == nil) != v.ok {
+			t.Errorf("Error on case %d: %v(actual) != %t(expected)", i, err, v.ok)
+		}
+		if n := atomic.LoadInt32(&hits); n != v.hits {
+			t.Errorf("Error on case %d: %d(actual) != %d(expected) requests", i, n, v.hits)
+		}
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	srv := newIPServer("203.0.113.1")
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var networks []string
+	c := NewClient(WithSources("http://pubip.invalid"), WithTimeout(100*time.Millisecond), WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		networks = append(networks, network+" "+addr)
+		mu.Unlock()
+		return net.Dial("tcp", srv.Listener.Addr().String())
+	}))
+	if _, err := c.GetIPv4(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	c.GetIPv6()
+	expected := []string{"tcp4 pubip.invalid:80", "tcp6 pubip.invalid:80"}
+	if !reflect.DeepEqual(networks, expected) {
+		t.Errorf("%v(actual) != %v(expected)", networks, expected)
+	}
+}
+
+func TestTransportTuning(t *testing.T) {
+	var conns int32
+	var proto atomic.Value
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto.Store(r.Proto)
+		w.Write([]byte("203.0.113.1"))
+	}))
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	tests := []struct {
+		opts  []Option
+		conns int32
+		proto string
+	}{
+		{nil, 1, "HTTP/2.0"},
+		{[]Option{WithDisableHTTP2(true)}, 1, "HTTP/1.1"},
+		{[]Option{WithDisableHTTP2(true), WithIdleConns(0, time.Nanosecond)}, 2, "HTTP/1.1"},
+	}
+	for i, v := range tests {
+		atomic.StoreInt32(&conns, 0)
+		opts := append([]Option{WithTLSConfig(&tls.Config{RootCAs: pool})}, v.opts...)
+		for n := 0; n < 2; n++ {
+			if _, err := GetIPBy(srv.URL, opts...); err != nil {
+				t.Fatalf("Error on case %d: unexpected error %s", i, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := atomic.LoadInt32(&conns); n != v.conns {
+			t.Errorf("Error on case %d: %d(actual) != %d(expected) connections", i, n, v.conns)
+		}
+		if p := proto.Load().(string); p != v.proto {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, p, v.proto)
+		}
+	}
+}
+
+func TestFallbackDelay(t *testing.T) {
+	// The server only listens on IPv4, while localhost may also resolve to an
+	// IPv6 address.
+	srv := newIPServer("203.0.113.1")
+	defer srv.Close()
+	uri := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	for i, d := range []time.Duration{0, 50 * time.Millisecond, -1} {
+		c := NewClient(WithFallbackDelay(d), WithMaxTries(1))
+		if d != 0 && c.dialClient(anyFamily) == c.httpClient() {
+			t.Errorf("Error on case %d: expected a dedicated transport", i)
+		}
+		if _, err := c.GetIPBy(uri); err != nil {
+			t.Errorf("Error on case %d: unexpected error %s", i, err)
+		}
+	}
+}
+
+func TestTransportTimeouts(t *testing.T) {
+	// The listener accepts the connections but never speaks TLS.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer slow.Close()
+
+	tests := []struct {
+		uri      string
+		opts     []Option
+		expected string
+	}{
+		{"https://" + silent.Addr().String(), []Option{WithTransportTimeouts(0, 50*time.Millisecond, 0)}, "TLS handshake timeout"},
+		{slow.URL, []Option{WithTransportTimeouts(0, 0, 50*time.Millisecond)}, "timeout awaiting response headers"},
+		{slow.URL, []Option{WithTransportTimeouts(50*time.Millisecond, 50*time.Millisecond, 0)}, ""},
+	}
+	for i, v := range tests {
+		start := time.Now()
+		_, err := GetIPBy(v.uri, append([]Option{WithMaxTries(1)}, v.opts...)...)
+		switch {
+		case v.expected == "" && err != nil:
+			t.Errorf("Error on case %d: unexpected error %s", i, err)
+		case v.expected != "" && (err == nil || !strings.Contains(err.Error(), v.expected)):
+			t.Errorf("Error on case %d: %v(actual) != %s(expected)", i, err, v.expected)
+		case v.expected != "" && time.Since(start) > 250*time.Millisecond:
+			t.Errorf("Error on case %d: expected to fail fast, took %s", i, time.Since(start))
+		}
+	}
+}
+
+func TestCloseReleasesTransports(t *testing.T) {
+	count := func(config *tls.Config) int {
+		n := 0
+		dialClients.Range(func(k, _ interface{}) bool {
+			if k.(dialClientKey).tls == config {
+				n++
+			}
+			return true
+		})
+		return n
+	}
+	config := &tls.Config{}
+	c := NewClient(WithTLSConfig(config))
+	c.dialClient(IPv4)
+	c.dialClient(IPv6)
+	if n := count(config); n != 2 {
+		t.Errorf("%d(actual) != %d(expected) transports", n, 2)
+	}
+	c.Close()
+	if n := count(config); n != 0 {
+		t.Errorf("%d(actual) != %d(expected) transports after Close", n, 0)
+	}
+}
@@ -0,0 +1,154 @@
+package pubip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSourceList is the maximum size in bytes of a list of services.
+const maxSourceList = 1 << 20
+
+// sourceLists caches the last list of services fetched from each URL.
+var sourceLists = struct {
+	sync.Mutex
+	m map[string][]string
+}{m: map[string][]string{}}
+
+// LoadSourcesFromURL fetches a list of URIs of services from uri, to be used
+// as the APIURIs of a Client, such as a list shared by a fleet of machines.
+// The list is either a JSON array of strings, or a URI per line, without the
+// empty lines and the ones starting with "#". It is a plain request with
+// HTTPClient and UserAgent, which doesn't need the public IP address. An error
+// is returned if one of the URIs is not valid. If the list cannot be fetched,
+// the last one fetched from uri, if any, is returned along with the error.
+//
+// Usage:
+//
+//		uris, err := pubip.LoadSourcesFromURL(ctx, "https://config.example.com/pubip-sources.json")
+//		if err != nil && uris == nil {
+//			return err
+//		}
+//		ip, err := pubip.Get(pubip.WithSources(uris...))
+func LoadSourcesFromURL(ctx context.Context, uri string) ([]string, error) {
+	uris, err := fetchSources(ctx, uri)
+	sourceLists.Lock()
+	defer sourceLists.Unlock()
+	if err != nil {
+		return append([]string(nil), sourceLists.m[uri]...), err
+	}
+	sourceLists.m[uri] = uris
+	return append([]string(nil), uris...), nil
+}
+
+// WatchSourcesFromURL fetches the list of services of uri, like
+// LoadSourcesFromURL, every interval and sends it when it differs from the
+// last one, starting with the first one fetched. The errors are sent on the
+// second channel, which doesn't need to be read, as in Watch. Both channels
+// are closed once ctx is done. An interval of zero or less is replaced by a
+// minute, as in Watch.
+func WatchSourcesFromURL(ctx context.Context, uri string, interval time.Duration) (<-chan []string, <-chan error) {
+	if interval <= 0 {
+		interval = watchInterval
+	}
+	lists := make(chan []string)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(lists)
+		defer close(errs)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		var last []string
+		for {
+			uris, err := fetchSources(ctx, uri)
+			if ctx.Err() != nil {
+				return
+			}
+			switch {
+			case err != nil:
+				select {
+				case errs <- err:
+				default:
+				}
+			case !reflect.DeepEqual(uris, last):
+				sourceLists.Lock()
+				sourceLists.m[uri] = uris
+				sourceLists.Unlock()
+				last = uris
+				select {
+				case lists <- append([]string(nil), uris...):
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return lists, errs
+}
+
+// fetchSources fetches and checks the list of services of uri.
+func fetchSources(ctx context.Context, uri string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	if UserAgent != "" {
+		req.Header.Set("User-Agent", UserAgent)
+	}
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch the services from %s: %w", uri, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSourceList+1))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch the services from %s: %w", uri, err)
+	}
+	if len(body) > maxSourceList {
+		return nil, fmt.Errorf("List of services of %s longer than %d bytes", uri, maxSourceList)
+	}
+	if resp.StatusCode != 200 {
+		return nil, NewClient().statusErr(uri, resp, body)
+	}
+	return parseSources(body)
+}
+
+// parseSources parses a JSON array of URIs, or else a URI per line, and checks
+// them.
+func parseSources(body []byte) ([]string, error) {
+	var uris []string
+	if text := strings.TrimSpace(string(body)); strings.HasPrefix(text, "[") {
+		if err := json.Unmarshal([]byte(text), &uris); err != nil {
+			return nil, fmt.Errorf("Invalid list of services: %w", err)
+		}
+	} else {
+		for _, line := range strings.Split(text, "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				uris = append(uris, line)
+			}
+		}
+	}
+	if len(uris) == 0 {
+		return nil, errors.New("Empty list of services")
+	}
+	for _, uri := range uris {
+		if err := checkURL(uri); err != nil {
+			return nil, fmt.Errorf("Invalid list of services: %w", err)
+		}
+	}
+	return uris, nil
+}
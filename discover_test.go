@@ -0,0 +1,167 @@
+package pubip
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadSourcesFromURL(t *testing.T) {
+	tests := []struct {
+		status   int
+		body     string
+		expected []string
+		err      string
+	}{
+		{200, "https://api.ipify.org\n# Backup\n\nhttp://ident.me\n", []string{"https://api.ipify.org", "http://ident.me"}, ""},
+		{200, `["https://api.ipify.org", "http://ident.me"]`, []string{"https://api.ipify.org", "http://ident.me"}, ""},
+		{200, "https://api.ipify.org\nident.me\n", nil, `Invalid list of services: "ident.me" is not an HTTP URL`},
+		{200, `["https://api.ipify.org", 3]`, nil, "Invalid list of services: json: cannot unmarshal number"},
+		{404, "Not Found", nil, " status code 404, body: Not Found"},
+		{200, "# Nothing yet\n", nil, "Empty list of services"},
+	}
+	for i, v := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(v.status)
+			w.Write([]byte(v.body))
+		}))
+		uris, err := LoadSourcesFromURL(context.Background(), srv.URL)
+		srv.Close()
+		if err != nil {
+			if !strings.Contains(err.Error(), v.err) || v.err == "" {
+				t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, err, v.err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(uris, v.expected) {
+			t.Errorf("Error on case %d: %v(actual) != %v(expected)", i, uris, v.expected)
+		}
+	}
+}
+
+func TestLoadSourcesFromURLCache(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) > 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("https://api.ipify.org\n"))
+	}))
+	defer srv.Close()
+
+	expected := []string{"https://api.ipify.org"}
+	if uris, err := LoadSourcesFromURL(context.Background(), srv.URL); err != nil || !reflect.DeepEqual(uris, expected) {
+		t.Fatalf("%v, %v(actual) != %v(expected)", uris, err, expected)
+	}
+	uris, err := LoadSourcesFromURL(context.Background(), srv.URL)
+	if err == nil {
+		t.Error("Expected the failure to fetch the list")
+	}
+	if !reflect.DeepEqual(uris, expected) {
+		t.Errorf("Expected the cached list, got %v", uris)
+	}
+}
+
+func TestWatchSourcesFromURL(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&hits, 1) {
+		case 1, 2:
+			w.Write([]byte("https://api.ipify.org\n"))
+		case 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("http://ident.me\n"))
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lists, errs := WatchSourcesFromURL(ctx, srv.URL, 10*time.Millisecond)
+	expected := [][]string{{"https://api.ipify.org"}, {"http://ident.me"}}
+	var got [][]string
+	var failures int
+	for len(got) < len(expected) {
+		select {
+		case uris := <-lists:
+			got = append(got, uris)
+		case <-errs:
+			failures++
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out with %v", got)
+		}
+	}
+	if !reflect.DeepEqual(got, expected) || failures != 1 {
+		t.Errorf("%v, %d(actual) != %v, %d(expected)", got, failures, expected, 1)
+	}
+	cancel()
+	for range lists {
+	}
+}
+
+func TestWatchSourcesFromURLWithoutReadingErrors(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) <= 5 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("https://api.ipify.org\n"))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lists, _ := WatchSourcesFromURL(ctx, srv.URL, 10*time.Millisecond)
+	select {
+	case uris := <-lists:
+		if expected := []string{"https://api.ipify.org"}; !reflect.DeepEqual(uris, expected) {
+			t.Errorf("%v(actual) != %v(expected)", uris, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the list although the errors are not read")
+	}
+}
+
+func TestWatchSourcesFromURLNonPositiveInterval(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("https://api.ipify.org\n"))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lists, _ := WatchSourcesFromURL(ctx, srv.URL, 0)
+	select {
+	case uris := <-lists:
+		if expected := []string{"https://api.ipify.org"}; !reflect.DeepEqual(uris, expected) {
+			t.Errorf("%v(actual) != %v(expected)", uris, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Nothing received")
+	}
+}
+
+func TestLoadSourcesFromURLNegativeMaxBodySize(t *testing.T) {
+	defer func(n int64) { MaxBodySize = n }(MaxBodySize)
+	MaxBodySize = -1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("down for maintenance"))
+	}))
+	defer srv.Close()
+
+	_, err := LoadSourcesFromURL(context.Background(), srv.URL)
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable || len(statusErr.Body) != 0 {
+		t.Errorf("Expected an empty body of the status error, got %v", err)
+	}
+}
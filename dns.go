@@ -0,0 +1,93 @@
+package pubip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// OpenDNS answers the address of the querier to A and AAAA queries of
+// myip.opendns.com.
+var OpenDNS = DNSSource{
+	Server: "resolver1.opendns.com:53",
+	Name:   "myip.opendns.com",
+}
+
+// GoogleDNS answers the address of the querier to TXT queries of
+// o-o.myaddr.l.google.com.
+var GoogleDNS = DNSSource{
+	Server: "ns1.google.com:53",
+	Name:   "o-o.myaddr.l.google.com",
+	TXT:    true,
+}
+
+// DNSSource is a name server answering the address of the querier. Queries are
+// sent to Server directly, bypassing the resolvers of the system.
+//
+// Usage:
+//
+//		pubip.Sources = append(pubip.Sources, pubip.OpenDNS, pubip.GoogleDNS)
+type DNSSource struct {
+	// Server is the address of the name server, including the port.
+	Server string
+	// Name is the name to look up.
+	Name string
+	// TXT looks up TXT records holding the address instead of A and AAAA
+	// records.
+	TXT bool
+	// Timeout is the time limit of the lookup, instead of the RequestTimeout
+	// of the Client when not zero.
+	Timeout time.Duration
+}
+
+// Lookup queries the name server.
+func (s DNSSource) Lookup(ctx context.Context) (net.IP, error) {
+	return s.lookup(ctx, anyFamily, NewClient().dial())
+}
+
+func (s DNSSource) String() string {
+	if s.TXT {
+		return "dns://" + s.Server + "/" + s.Name + "?type=TXT"
+	}
+	return "dns://" + s.Server + "/" + s.Name
+}
+
+// lookup queries the name server over the network of the family, connecting
+// with dial.
+func (s DNSSource) lookup(ctx context.Context, f Family, dial dialFunc) (net.IP, error) {
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dial(ctx, f.network(network), s.Server)
+		},
+	}
+
+	if !s.TXT {
+		network := "ip"
+		switch f {
+		case IPv4:
+			network = "ip4"
+		case IPv6:
+			network = "ip6"
+		}
+		ips, err := r.LookupIP(ctx, network, s.Name)
+		if err != nil {
+			return nil, err
+		}
+		return ips[0], nil
+	}
+
+	txts, err := r.LookupTXT(ctx, s.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, txt := range txts {
+		ip := parseIP(strings.Trim(strings.TrimSpace(txt), `"`))
+		if ip != nil && f.match(ip) {
+			return ip, nil
+		}
+	}
+	return nil, errors.New("No " + f.String() + " address in TXT records of " + s.Name + ": " + strings.Join(txts, ", "))
+}
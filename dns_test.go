@@ -0,0 +1,83 @@
+package pubip
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newDNSServer starts a name server answering a to A queries, txt to TXT
+// queries and ptrs to PTR queries, whatever the name.
+func newDNSServer(t *testing.T, a net.IP, txt string, ptrs ...string) string {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var m dnsmessage.Message
+			if err := m.Unpack(buf[:n]); err != nil || len(m.Questions) == 0 {
+				continue
+			}
+			q := m.Questions[0]
+			m.Header.Response = true
+			m.Header.Authoritative = true
+			hdr := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: 60}
+			switch q.Type {
+			case dnsmessage.TypeA:
+				var r dnsmessage.AResource
+				copy(r.A[:], a.To4())
+				m.Answers = []dnsmessage.Resource{{Header: hdr, Body: &r}}
+			case dnsmessage.TypeTXT:
+				m.Answers = []dnsmessage.Resource{{Header: hdr, Body: &dnsmessage.TXTResource{TXT: []string{txt}}}}
+			case dnsmessage.TypePTR:
+				for _, ptr := range ptrs {
+					m.Answers = append(m.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(ptr)}})
+				}
+			}
+			out, err := m.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(out, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDNSSource(t *testing.T) {
+	expected := net.ParseIP("203.0.113.1")
+	server := newDNSServer(t, expected, `"203.0.113.1"`)
+
+	tests := []DNSSource{
+		{Server: server, Name: "myip.opendns.com"},
+		{Server: server, Name: "o-o.myaddr.l.google.com", TXT: true},
+	}
+	for i, v := range tests {
+		actual, err := v.Lookup(context.Background())
+		if err != nil {
+			t.Errorf("Error on case %d: %s", i, err)
+			continue
+		}
+		if !actual.Equal(expected) {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, actual, expected)
+		}
+	}
+}
+
+func TestDNSSourceInvalidTXT(t *testing.T) {
+	server := newDNSServer(t, nil, "edns0-client-subnet 198.51.100.0/24")
+	s := DNSSource{Server: server, Name: "o-o.myaddr.l.google.com", TXT: true}
+	if _, err := s.Lookup(context.Background()); err == nil {
+		t.Error("Expected an error without any address in the TXT records")
+	}
+}
@@ -0,0 +1,59 @@
+package pubip
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClientFromEnv returns a Client like NewClient, with the settings found in
+// the environment:
+//
+//		PUBIP_SOURCES    the URIs of the services, separated by commas
+//		PUBIP_TIMEOUT    the Timeout, such as "5s"
+//		PUBIP_QUORUM     the Quorum
+//		PUBIP_MAX_TRIES  the MaxTries
+//
+// The package level settings are used for the unset or empty ones. An error is
+// returned if one of them is not valid.
+func ClientFromEnv() (*Client, error) {
+	c := NewClient()
+	if v := os.Getenv("PUBIP_SOURCES"); v != "" {
+		var uris []string
+		for _, uri := range strings.Split(v, ",") {
+			uri = strings.TrimSpace(uri)
+			if err := checkURL(uri); err != nil {
+				return nil, fmt.Errorf("Invalid PUBIP_SOURCES: %w", err)
+			}
+			uris = append(uris, uri)
+		}
+		c.APIURIs = uris
+	}
+	if v := os.Getenv("PUBIP_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("Invalid PUBIP_TIMEOUT: %q is not a positive duration", v)
+		}
+		c.Timeout = d
+	}
+	for _, s := range []struct {
+		name string
+		dst  *int
+	}{
+		{"PUBIP_QUORUM", &c.Quorum},
+		{"PUBIP_MAX_TRIES", &c.MaxTries},
+	} {
+		v := os.Getenv(s.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("Invalid %s: %q is not a positive integer", s.name, v)
+		}
+		*s.dst = n
+	}
+	return c, nil
+}
@@ -0,0 +1,55 @@
+package pubip
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClientFromEnv(t *testing.T) {
+	tests := []struct {
+		env      map[string]string
+		sources  []string
+		timeout  time.Duration
+		quorum   int
+		maxTries int
+		err      string
+	}{
+		{map[string]string{}, APIURIs, Timeout, Quorum, MaxTries, ""},
+		{
+			map[string]string{
+				"PUBIP_SOURCES":   "https://api.ipify.org, http://ident.me",
+				"PUBIP_TIMEOUT":   "5s",
+				"PUBIP_QUORUM":    "2",
+				"PUBIP_MAX_TRIES": "1",
+			},
+			[]string{"https://api.ipify.org", "http://ident.me"}, 5 * time.Second, 2, 1, "",
+		},
+		{map[string]string{"PUBIP_SOURCES": "https://api.ipify.org,ident.me"}, nil, 0, 0, 0, `Invalid PUBIP_SOURCES: "ident.me" is not an HTTP URL`},
+		{map[string]string{"PUBIP_TIMEOUT": "5"}, nil, 0, 0, 0, `Invalid PUBIP_TIMEOUT: "5" is not a positive duration`},
+		{map[string]string{"PUBIP_QUORUM": "-1"}, nil, 0, 0, 0, `Invalid PUBIP_QUORUM: "-1" is not a positive integer`},
+		{map[string]string{"PUBIP_MAX_TRIES": "many"}, nil, 0, 0, 0, `Invalid PUBIP_MAX_TRIES: "many" is not a positive integer`},
+	}
+	for i, v := range tests {
+		for _, name := range []string{"PUBIP_SOURCES", "PUBIP_TIMEOUT", "PUBIP_QUORUM", "PUBIP_MAX_TRIES"} {
+			t.Setenv(name, v.env[name])
+		}
+		c, err := ClientFromEnv()
+		if err != nil {
+			if err.Error() != v.err {
+				t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, err, v.err)
+			}
+			continue
+		}
+		if v.err != "" {
+			t.Errorf("Error on case %d: expected the error %s", i, v.err)
+			continue
+		}
+		if !reflect.DeepEqual(c.APIURIs, v.sources) {
+			t.Errorf("Error on case %d: %v(actual) != %v(expected)", i, c.APIURIs, v.sources)
+		}
+		if c.Timeout != v.timeout || c.Quorum != v.quorum || c.MaxTries != v.maxTries {
+			t.Errorf("Error on case %d: %s, %d, %d(actual) != %s, %d, %d(expected)", i, c.Timeout, c.Quorum, c.MaxTries, v.timeout, v.quorum, v.maxTries)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package pubip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInsufficientSources is matched by `errors.Is` when fewer services than
+// Quorum answered, or their weight is below MinWeight, without disagreeing.
+var ErrInsufficientSources = errors.New("Insufficient sources")
+
+// ErrNoConsensus is matched by `errors.Is` when the services answered
+// different addresses, see DisagreementError.
+var ErrNoConsensus = errors.New("No consensus")
+
+// insufficientError is a failure matching ErrInsufficientSources with its own
+// message.
+type insufficientError struct {
+	msg string
+}
+
+// insufficient returns the failure matching ErrInsufficientSources with the
+// formatted message.
+func insufficient(format string, a ...interface{}) error {
+	return &insufficientError{fmt.Sprintf(format, a...)}
+}
+
+func (e *insufficientError) Error() string {
+	return e.msg
+}
+
+func (e *insufficientError) Unwrap() error {
+	return ErrInsufficientSources
+}
+
+// ErrOutsideExpectedRange is matched by `errors.Is` when the services agreed on
+// an address out of ExpectedNetworks, see OutsideRangeError.
+var ErrOutsideExpectedRange = errors.New("Outside the expected networks")
+
+// OutsideRangeError is the failure of a consensus on an address which doesn't
+// belong to any of ExpectedNetworks, such as when a service is hijacked or
+// the traffic leaves through another ISP.
+type OutsideRangeError struct {
+	// IP is the address the services agreed on.
+	IP net.IP
+	// Networks is the expected networks.
+	Networks []*net.IPNet
+}
+
+func (e *OutsideRangeError) Error() string {
+	var nets []string
+	for _, n := range e.Networks {
+		nets = append(nets, n.String())
+	}
+	return "IP address " + e.IP.String() + " outside the expected networks: " + strings.Join(nets, ", ")
+}
+
+// Is reports whether target is ErrOutsideExpectedRange.
+func (e *OutsideRangeError) Is(target error) bool {
+	return target == ErrOutsideExpectedRange
+}
+
+// SourceError is the failure of a service.
+type SourceError struct {
+	// Source is the URI of the service.
+	Source string
+	// Err is the reason of the failure.
+	Err error
+	// Duration is the time Source took to fail, retries included. It's zero
+	// when it wasn't queried.
+	Duration time.Duration
+	// Retries is the amount of retries of Source before it failed.
+	Retries int
+}
+
+func (e SourceError) Error() string {
+	return e.Err.Error()
+}
+
+func (e SourceError) Unwrap() error {
+	return e.Err
+}
+
+// maxParseErrorValue is the length beyond which the answer of a service is
+// shortened in the message of a ParseError.
+const maxParseErrorValue = 64
+
+// ParseError is the failure to find an IP address in the answer of a service,
+// such as an HTML error page. It is the Err of the SourceError of the service
+// in an AggregateError.
+//
+// Usage:
+//
+//		var parseErr *pubip.ParseError
+//		if _, err := pubip.Get(); errors.As(err, &parseErr) {
+//			fmt.Printf("A service answered %q\n", parseErr.Body)
+//		}
+type ParseError struct {
+	// Body is the answer of the service, at most MaxBodySize bytes.
+	Body []byte
+	// Value is what was extracted from Body, which isn't an IP address.
+	Value string
+	// Err is the failure of the ParseFunc of the service, nil if it extracted
+	// Value.
+	Err error
+}
+
+// Error returns the failure of the ParseFunc, or Value quoted and shortened.
+// Body is left out.
+func (e *ParseError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	v := e.Value
+	if len(v) > maxParseErrorValue {
+		return "IP address not valid: " + strconv.Quote(v[:maxParseErrorValue]) + "..."
+	}
+	if strconv.Quote(v) != `"`+v+`"` {
+		return "IP address not valid: " + strconv.Quote(v)
+	}
+	return "IP address not valid: " + v
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatusError is the failure of a service which answered another status
+// code than 200, such as 429 when it rate limits this machine or 403 when it
+// blocks it. It is the Err of the SourceError of the service in an
+// AggregateError.
+//
+// Usage:
+//
+//		var statusErr *pubip.HTTPStatusError
+//		if _, err := pubip.Get(); errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests {
+//			fmt.Println("Rate limited by", statusErr.URL)
+//		}
+type HTTPStatusError struct {
+	// URL is the URI of the service.
+	URL string
+	// StatusCode is the status code of the answer.
+	StatusCode int
+	// Body is the answer of the service, cut to MaxBodySize bytes.
+	Body []byte
+}
+
+func (e *HTTPStatusError) Error() string {
+	return e.URL + " status code " + strconv.Itoa(e.StatusCode) + ", body: " + string(e.Body)
+}
+
+// Vote is an address answered by several services.
+type Vote struct {
+	// IP is the address.
+	IP net.IP
+	// Sources is the URIs of the services which answered IP.
+	Sources []string
+}
+
+// DisagreementError is the failure of a consensus because the services
+// answered different addresses.
+type DisagreementError struct {
+	// Reason is the requirement of the consensus which wasn't met.
+	Reason string
+	// Votes is the distinct addresses answered, the most frequent first,
+	// then in the order of their text.
+	Votes []Vote
+}
+
+// newDisagreementError returns the failure to meet reason with the votes of
+// rs.
+func newDisagreementError(reason string, rs []Result) *DisagreementError {
+	var votes []Vote
+	index := map[string]int{}
+	for _, r := range rs {
+		k := r.IP.String()
+		i, ok := index[k]
+		if !ok {
+			i = len(votes)
+			index[k] = i
+			votes = append(votes, Vote{IP: r.IP})
+		}
+		votes[i].Sources = append(votes[i].Sources, r.Source)
+	}
+	sort.Slice(votes, func(i, j int) bool {
+		if len(votes[i].Sources) != len(votes[j].Sources) {
+			return len(votes[i].Sources) > len(votes[j].Sources)
+		}
+		return votes[i].IP.String() < votes[j].IP.String()
+	})
+	return &DisagreementError{Reason: reason, Votes: votes}
+}
+
+// Error returns the reason followed by each address and the amount of
+// services which answered it, such as "Results are not identical:
+// 203.0.113.5 (2 sources), 198.51.100.9 (1 source)".
+func (e *DisagreementError) Error() string {
+	var votes []string
+	for _, v := range e.Votes {
+		n := len(v.Sources)
+		vote := v.IP.String() + " (" + strconv.Itoa(n) + " source"
+		if n != 1 {
+			vote += "s"
+		}
+		votes = append(votes, vote+")")
+	}
+	return e.Reason + ": " + strings.Join(votes, ", ")
+}
+
+// Is reports whether target is ErrNoConsensus.
+func (e *DisagreementError) Is(target error) bool {
+	return target == ErrNoConsensus
+}
+
+// AggregateError is returned when several services were queried but no IP
+// address could be agreed on. It supports `errors.Is` and `errors.As` on both
+// the reason and the failures of the services.
+//
+// Usage:
+//
+//		var opErr *net.OpError
+//		if _, err := pubip.Get(); errors.As(err, &opErr) {
+//			fmt.Println("A service is unreachable:", opErr)
+//		}
+type AggregateError struct {
+	// Err is the reason no IP address was agreed on.
+	Err error
+	// Errors is the failures of the services.
+	Errors []SourceError
+	// Results is the answers of the services which weren't enough to agree
+	// on an address.
+	Results []Result
+}
+
+// Error returns the reason followed by the failures and the answers of the
+// services, one per line.
+func (e *AggregateError) Error() string {
+	errStrs := []string{e.Err.Error()}
+	for _, se := range e.Errors {
+		errStrs = append(errStrs, se.Error())
+	}
+	for _, r := range e.Results {
+		errStrs = append(errStrs, r.Source+" answered "+r.IP.String())
+	}
+	return strings.Join(errStrs, "\n")
+}
+
+func (e *AggregateError) Unwrap() []error {
+	errs := []error{e.Err}
+	for _, se := range e.Errors {
+		errs = append(errs, se)
+	}
+	return errs
+}
+
+// DualStackError is returned by GetDualStack and GetByFamily when the lookup of
+// a family, or of both, failed. The address of the other family is returned
+// along with it. GetPreferred returns it when both failed.
+type DualStackError struct {
+	// IPv4 is the failure of the IPv4 lookup, nil if it succeeded.
+	IPv4 error
+	// IPv6 is the failure of the IPv6 lookup, nil if it succeeded.
+	IPv6 error
+}
+
+func (e *DualStackError) Error() string {
+	var errStrs []string
+	if e.IPv4 != nil {
+		errStrs = append(errStrs, "IPv4: "+e.IPv4.Error())
+	}
+	if e.IPv6 != nil {
+		errStrs = append(errStrs, "IPv6: "+e.IPv6.Error())
+	}
+	return strings.Join(errStrs, "\n")
+}
+
+func (e *DualStackError) Unwrap() []error {
+	var errs []error
+	for _, err := range []error{e.IPv4, e.IPv6} {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
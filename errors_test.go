@@ -0,0 +1,195 @@
+package pubip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAggregateError(t *testing.T) {
+	bad := newIPServer("not an IP")
+	defer bad.Close()
+	// Nothing listens on a closed server.
+	closed := newIPServer("")
+	closed.Close()
+
+	_, err := Get(WithSources(bad.URL, closed.URL), WithMaxTries(1), WithTimeout(200*time.Millisecond))
+	var aggErr *AggregateError
+	if !errors.As(err, &aggErr) {
+		t.Fatalf("Expected an *AggregateError, got %T: %v", err, err)
+	}
+	if len(aggErr.Errors) != 2 {
+		t.Errorf("Expected 2 failures, got %d", len(aggErr.Errors))
+	}
+	if lines := strings.Split(err.Error(), "\n"); len(lines) != 3 {
+		t.Errorf("Expected the reason and a line per failure, got %q", err.Error())
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Error("Expected to find the *net.OpError of the closed server")
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Unexpected deadline among the failures")
+	}
+}
+
+func TestAggregateErrorResults(t *testing.T) {
+	good := newIPServer("203.0.113.1")
+	defer good.Close()
+	bad := newIPServer("not an IP")
+	defer bad.Close()
+
+	_, err := Get(WithSources(good.URL, good.URL+"/other", bad.URL), WithTimeout(100*time.Millisecond))
+	var aggErr *AggregateError
+	if !errors.As(err, &aggErr) {
+		t.Fatalf("Expected an *AggregateError, got %T: %v", err, err)
+	}
+	if len(aggErr.Results) != 2 {
+		t.Errorf("Expected the 2 results, got %v", aggErr.Results)
+	}
+	expected := "Less than 3 results from 3 APIs"
+	if !strings.HasPrefix(err.Error(), expected) || !strings.Contains(err.Error(), good.URL+" answered 203.0.113.1") {
+		t.Errorf("Expected the reason and the results, got %q", err.Error())
+	}
+}
+
+func TestParseError(t *testing.T) {
+	page := "<html>\n<head><title>502 Bad Gateway</title></head>\n<body><center><h1>502 Bad Gateway</h1></center></body>\n</html>"
+	html := newIPServer(page)
+	defer html.Close()
+	js := newIPServer(`{"origin":"203.0.113.1"}`)
+	defer js.Close()
+
+	_, err := Get(
+		WithSources(html.URL),
+		WithExtraSources(HTTPSource{URL: js.URL, Parse: ParseJSON}),
+		WithMaxTries(1),
+		WithTimeout(200*time.Millisecond),
+	)
+	var aggErr *AggregateError
+	if !errors.As(err, &aggErr) {
+		t.Fatalf("Expected an *AggregateError, got %T: %v", err, err)
+	}
+	bodies := map[string]string{}
+	for _, se := range aggErr.Errors {
+		var parseErr *ParseError
+		if !errors.As(se, &parseErr) {
+			t.Fatalf("Expected a *ParseError from %s, got %T: %v", se.Source, se.Err, se.Err)
+		}
+		bodies[se.Source] = string(parseErr.Body)
+	}
+	if bodies[html.URL] != page {
+		t.Errorf("%q(actual) != %q(expected)", bodies[html.URL], page)
+	}
+	if expected := `{"origin":"203.0.113.1"}`; bodies[js.URL] != expected {
+		t.Errorf("%q(actual) != %q(expected)", bodies[js.URL], expected)
+	}
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"not an IP", "IP address not valid: not an IP"},
+		{"203.0.113.1\r", `IP address not valid: "203.0.113.1\r"`},
+		{page, `IP address not valid: "<html>\n<head><title>502 Bad Gateway</title></head>\n<body><center"...`},
+	}
+	for i, v := range tests {
+		if actual := (&ParseError{Value: v.value}).Error(); actual != v.expected {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, actual, v.expected)
+		}
+	}
+}
+
+func TestDisagreementError(t *testing.T) {
+	tests := []struct {
+		consensus ConsensusStrategy
+		answers   []string
+		expected  string
+	}{
+		{Unanimous, []string{"198.51.100.9", "203.0.113.5", "203.0.113.5"}, "Results are not identical: 203.0.113.5 (2 sources), 198.51.100.9 (1 source)"},
+		{Majority, []string{"198.51.100.9", "203.0.113.5", "203.0.113.6", "203.0.113.5"}, "No majority among results: 203.0.113.5 (2 sources), 198.51.100.9 (1 source), 203.0.113.6 (1 source)"},
+		{Weighted, []string{"198.51.100.9", "203.0.113.5"}, "No result outweighs the others: 198.51.100.9 (1 source), 203.0.113.5 (1 source)"},
+	}
+	for i, v := range tests {
+		var uris []string
+		for _, a := range v.answers {
+			srv := newIPServer(a)
+			defer srv.Close()
+			uris = append(uris, srv.URL)
+		}
+		_, err := Get(WithSources(uris...), WithQuorum(1), WithConsensus(v.consensus), WithTimeout(100*time.Millisecond))
+		var disErr *DisagreementError
+		if !errors.As(err, &disErr) {
+			t.Errorf("Error on case %d: expected a *DisagreementError, got %T: %v", i, err, err)
+			continue
+		}
+		if disErr.Error() != v.expected {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, disErr, v.expected)
+		}
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	tests := []struct {
+		answers  []string
+		expected error
+		other    error
+	}{
+		{[]string{"203.0.113.5", "203.0.113.5", "not an IP"}, ErrInsufficientSources, ErrNoConsensus},
+		{[]string{"203.0.113.5", "198.51.100.9", "not an IP"}, ErrNoConsensus, ErrInsufficientSources},
+		{[]string{"203.0.113.5", "198.51.100.9", "203.0.113.5"}, ErrNoConsensus, ErrInsufficientSources},
+		{[]string{"not an IP", "not an IP"}, ErrInsufficientSources, ErrNoConsensus},
+	}
+	for i, v := range tests {
+		var uris []string
+		for j, a := range v.answers {
+			srv := newIPServer(a)
+			defer srv.Close()
+			uris = append(uris, srv.URL+"/"+strconv.Itoa(j))
+		}
+		_, err := Get(WithSources(uris...), WithMaxTries(1), WithTimeout(100*time.Millisecond))
+		if !errors.Is(err, v.expected) || errors.Is(err, v.other) {
+			t.Errorf("Error on case %d: %v(actual) != %v(expected)", i, err, v.expected)
+		}
+	}
+}
+
+func TestHTTPStatusError(t *testing.T) {
+	tests := []struct {
+		code int
+		body string
+	}{
+		{http.StatusTooManyRequests, "Slow down"},
+		{http.StatusForbidden, "Blocked"},
+		{http.StatusInternalServerError, strings.Repeat("x", 1000)},
+	}
+	for i, v := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(v.code)
+			w.Write([]byte(v.body))
+		}))
+		defer srv.Close()
+
+		_, err := Get(WithSources(srv.URL), WithQuorum(1), WithMaxTries(1), WithTimeout(100*time.Millisecond))
+		var statusErr *HTTPStatusError
+		if !errors.As(err, &statusErr) {
+			t.Errorf("Error on case %d: expected an *HTTPStatusError, got %T: %v", i, err, err)
+			continue
+		}
+		if statusErr.URL != srv.URL || statusErr.StatusCode != v.code {
+			t.Errorf("Error on case %d: %s %d(actual) != %s %d(expected)", i, statusErr.URL, statusErr.StatusCode, srv.URL, v.code)
+		}
+		expected := v.body
+		if int64(len(expected)) > MaxBodySize {
+			expected = expected[:MaxBodySize]
+		}
+		if string(statusErr.Body) != expected {
+			t.Errorf("Error on case %d: %q(actual) != %q(expected)", i, statusErr.Body, expected)
+		}
+	}
+}
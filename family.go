@@ -0,0 +1,262 @@
+package pubip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Family is an address family, which restricts a lookup.
+type Family int
+
+const (
+	anyFamily Family = iota
+	// IPv4 is the family of the 32 bits addresses.
+	IPv4
+	// IPv6 is the family of the 128 bits addresses.
+	IPv6
+)
+
+func (f Family) String() string {
+	switch f {
+	case IPv4:
+		return "IPv4"
+	case IPv6:
+		return "IPv6"
+	}
+	return "IP"
+}
+
+// network returns the network to dial for the family, base being "tcp" or
+// "udp".
+func (f Family) network(base string) string {
+	switch f {
+	case IPv4:
+		return base + "4"
+	case IPv6:
+		return base + "6"
+	}
+	return base
+}
+
+// familyOf returns the family of ip.
+func familyOf(ip net.IP) Family {
+	if ip.To4() != nil {
+		return IPv4
+	}
+	return IPv6
+}
+
+// match reports whether ip belongs to the family.
+func (f Family) match(ip net.IP) bool {
+	switch f {
+	case IPv4:
+		return ip.To4() != nil
+	case IPv6:
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// MixedFamilyPolicy decides which address wins when the services answer
+// addresses of both families to a lookup of any family, such as Get. The
+// results of each family are compared with each other, never across families.
+type MixedFamilyPolicy int
+
+const (
+	// PreferIPv4 returns the IPv4 address when its results reach a
+	// consensus, or else the IPv6 one.
+	PreferIPv4 MixedFamilyPolicy = iota
+	// PreferIPv6 returns the IPv6 address when its results reach a
+	// consensus, or else the IPv4 one.
+	PreferIPv6
+	// RequireSameFamily fails the lookup when the services answer addresses
+	// of both families.
+	RequireSameFamily
+	// SplitByFamily returns the address of the family answered by the most
+	// services when its results reach a consensus, or else the one of the
+	// other family. IPv4 wins a tie. GetByFamily returns both addresses.
+	SplitByFamily
+)
+
+func (p MixedFamilyPolicy) String() string {
+	switch p {
+	case PreferIPv4:
+		return "prefer-ipv4"
+	case PreferIPv6:
+		return "prefer-ipv6"
+	case RequireSameFamily:
+		return "require-same-family"
+	case SplitByFamily:
+		return "split-by-family"
+	}
+	return fmt.Sprintf("MixedFamilyPolicy(%d)", int(p))
+}
+
+// families returns the families in the order validate tries them when the
+// results rs are of any family.
+func (p MixedFamilyPolicy) families(rs []Result) (first, second Family) {
+	switch {
+	case p == PreferIPv6:
+		return IPv6, IPv4
+	case p == SplitByFamily && count(rs, IPv6) > count(rs, IPv4):
+		return IPv6, IPv4
+	}
+	return IPv4, IPv6
+}
+
+// GetIPv4 is like Get but only queries the services over IPv4 and only
+// accepts IPv4 addresses.
+func GetIPv4(opts ...Option) (net.IP, error) {
+	return NewClient(opts...).GetIPv4()
+}
+
+// GetIPv4Str is like GetIPv4 but returns a `string`.
+func GetIPv4Str(opts ...Option) (string, error) {
+	ip, err := GetIPv4(opts...)
+	if err != nil {
+		return "", err
+	}
+	return ip.String(), nil
+}
+
+// GetIPv6 is like Get but only queries the services over IPv6 and only
+// accepts IPv6 addresses.
+func GetIPv6(opts ...Option) (net.IP, error) {
+	return NewClient(opts...).GetIPv6()
+}
+
+// GetIPv6Str is like GetIPv6 but returns a `string`.
+func GetIPv6Str(opts ...Option) (string, error) {
+	ip, err := GetIPv6(opts...)
+	if err != nil {
+		return "", err
+	}
+	return ip.String(), nil
+}
+
+// GetDualStack looks up both the IPv4 and the IPv6 addresses at the same time,
+// like GetIPv4Str and GetIPv6Str. If any of them fails, err is a
+// `*DualStackError` telling which, and the other address is still returned.
+func GetDualStack(opts ...Option) (v4, v6 string, err error) {
+	return NewClient(opts...).GetDualStack()
+}
+
+// GetByFamily queries several APIs once, like Get, but requires a consensus
+// among the answers of each address family separately. The addresses are keyed
+// by "ipv4" and "ipv6". If any of them fails, err is a `*DualStackError`
+// telling which, and the other address is still returned.
+func GetByFamily(opts ...Option) (map[string]string, error) {
+	return NewClient(opts...).GetByFamily()
+}
+
+// GetPreferred is like GetIPv4 or GetIPv6, for the preferred family f, but
+// falls back to the other family when f fails, such as to register the IPv6
+// address with a DDNS when there is one. It also returns the family of the
+// address. If both fail, err is a `*DualStackError`.
+func GetPreferred(f Family, opts ...Option) (net.IP, Family, error) {
+	return NewClient(opts...).GetPreferred(f)
+}
+
+// GetIPv4 is like Get but only queries the services over IPv4 and only
+// accepts IPv4 addresses.
+func (c *Client) GetIPv4() (net.IP, error) {
+	r, err := c.get(context.Background(), IPv4)
+	return r.IP, err
+}
+
+// GetIPv6 is like Get but only queries the services over IPv6 and only
+// accepts IPv6 addresses.
+func (c *Client) GetIPv6() (net.IP, error) {
+	r, err := c.get(context.Background(), IPv6)
+	return r.IP, err
+}
+
+// GetDualStack looks up both the IPv4 and the IPv6 addresses at the same time,
+// like GetIPv4Str and GetIPv6Str. If any of them fails, err is a
+// `*DualStackError` telling which, and the other address is still returned.
+func (c *Client) GetDualStack() (v4, v6 string, err error) {
+	var r4, r6 Result
+	var err4, err6 error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r4, err4 = c.get(context.Background(), IPv4)
+	}()
+	go func() {
+		defer wg.Done()
+		r6, err6 = c.get(context.Background(), IPv6)
+	}()
+	wg.Wait()
+
+	if err4 == nil {
+		v4 = r4.IP.String()
+	}
+	if err6 == nil {
+		v6 = r6.IP.String()
+	}
+	if err4 != nil || err6 != nil {
+		err = &DualStackError{err4, err6}
+	}
+	return v4, v6, err
+}
+
+// GetByFamily queries several APIs once, like Get, but requires a consensus
+// among the answers of each address family separately. The addresses are keyed
+// by "ipv4" and "ipv6". If any of them fails, err is a `*DualStackError`
+// telling which, and the other address is still returned.
+func (c *Client) GetByFamily() (map[string]string, error) {
+	rs, errs := c.collect(context.Background(), anyFamily)
+	ips := map[string]string{}
+	var famErrs [2]error
+	for i, f := range []Family{IPv4, IPv6} {
+		var frs []Result
+		for _, r := range rs {
+			if f.match(r.IP) {
+				frs = append(frs, r)
+			}
+		}
+		r, err := c.decide(frs, errs, f)
+		if err != nil {
+			famErrs[i] = &AggregateError{err, errs, frs}
+			continue
+		}
+		ips[strings.ToLower(f.String())] = r.IP.String()
+	}
+	if famErrs[0] != nil || famErrs[1] != nil {
+		return ips, &DualStackError{famErrs[0], famErrs[1]}
+	}
+	return ips, nil
+}
+
+// GetPreferred is like GetIPv4 or GetIPv6, for the preferred family f, but
+// falls back to the other family when f fails, such as to register the IPv6
+// address with a DDNS when there is one. It also returns the family of the
+// address. If both fail, err is a `*DualStackError`.
+func (c *Client) GetPreferred(f Family) (net.IP, Family, error) {
+	var other Family
+	switch f {
+	case IPv4:
+		other = IPv6
+	case IPv6:
+		other = IPv4
+	default:
+		return nil, f, fmt.Errorf("Unknown address family %d", f)
+	}
+	r, errF := c.get(context.Background(), f)
+	if errF == nil {
+		return r.IP, f, nil
+	}
+	c.debugf("No %s address, falling back to %s: %s", f, other, errF)
+	r, errOther := c.get(context.Background(), other)
+	if errOther == nil {
+		return r.IP, other, nil
+	}
+	if f == IPv4 {
+		return nil, f, &DualStackError{IPv4: errF, IPv6: errOther}
+	}
+	return nil, f, &DualStackError{IPv4: errOther, IPv6: errF}
+}
@@ -0,0 +1,138 @@
+package pubip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFamilyMatch(t *testing.T) {
+	tests := []struct {
+		f        Family
+		input    net.IP
+		expected bool
+	}{
+		{anyFamily, net.ParseIP("192.168.1.1"), true},
+		{anyFamily, net.ParseIP("2001:db8::1"), true},
+		{IPv4, net.ParseIP("192.168.1.1"), true},
+		{IPv4, net.ParseIP("2001:db8::1"), false},
+		{IPv6, net.ParseIP("192.168.1.1"), false},
+		{IPv6, net.ParseIP("2001:db8::1"), true},
+	}
+	for i, v := range tests {
+		if actual := v.f.match(v.input); actual != v.expected {
+			t.Errorf("Error on case %d: %t(actual) != %t(expected)", i, actual, v.expected)
+		}
+	}
+}
+
+func TestGetIPByRejectsOtherFamily(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("2001:db8::1"))
+	}))
+	defer srv.Close()
+
+	if _, _, err := NewClient().lookup(context.Background(), HTTPSource{URL: srv.URL}, IPv4); err == nil {
+		t.Error("Expected an IPv6 address to be rejected by an IPv4 lookup")
+	}
+	if _, _, err := NewClient().lookup(context.Background(), HTTPSource{URL: srv.URL}, anyFamily); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestGetDualStack(t *testing.T) {
+	// The server only listens on IPv4, so that the IPv6 lookup fails.
+	srv := newIPServer("203.0.113.1")
+	defer srv.Close()
+
+	v4, v6, err := GetDualStack(WithSources(srv.URL), WithTimeout(100*time.Millisecond))
+	if v4 != "203.0.113.1" || v6 != "" {
+		t.Errorf("%q, %q(actual) != %q, %q(expected)", v4, v6, "203.0.113.1", "")
+	}
+	var dsErr *DualStackError
+	if !errors.As(err, &dsErr) || dsErr.IPv4 != nil || dsErr.IPv6 == nil {
+		t.Errorf("Expected a failure of IPv6 only, got %v", err)
+	}
+}
+
+func TestGetByFamily(t *testing.T) {
+	tests := []struct {
+		answers  []string
+		expected map[string]string
+		err4     bool
+		err6     bool
+	}{
+		{[]string{"203.0.113.1", "2001:db8::1", "203.0.113.1", "2001:db8::1"}, map[string]string{"ipv4": "203.0.113.1", "ipv6": "2001:db8::1"}, false, false},
+		{[]string{"203.0.113.1", "2001:db8::1", "203.0.113.1", "2001:db8::2"}, map[string]string{"ipv4": "203.0.113.1"}, false, true},
+		{[]string{"203.0.113.1", "203.0.113.2", "2001:db8::1", "2001:db8::1"}, map[string]string{"ipv6": "2001:db8::1"}, true, false},
+	}
+	for i, v := range tests {
+		var uris []string
+		for _, a := range v.answers {
+			srv := newIPServer(a)
+			defer srv.Close()
+			uris = append(uris, srv.URL)
+		}
+		ips, err := GetByFamily(WithSources(uris...), WithQuorum(2), WithTimeout(100*time.Millisecond))
+		if !reflect.DeepEqual(ips, v.expected) {
+			t.Errorf("Error on case %d: %v(actual) != %v(expected)", i, ips, v.expected)
+		}
+		var dsErr *DualStackError
+		if !v.err4 && !v.err6 {
+			if err != nil {
+				t.Errorf("Error on case %d: unexpected error %s", i, err)
+			}
+			continue
+		}
+		if !errors.As(err, &dsErr) {
+			t.Errorf("Error on case %d: expected a *DualStackError, got %T: %v", i, err, err)
+			continue
+		}
+		if (dsErr.IPv4 != nil) != v.err4 || (dsErr.IPv6 != nil) != v.err6 {
+			t.Errorf("Error on case %d: unexpected failures %v", i, dsErr)
+		}
+	}
+}
+
+func TestGetPreferred(t *testing.T) {
+	// Both families reach the server, which only listens on IPv4.
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	tests := []struct {
+		answer    string
+		preferred Family
+		expected  string
+		family    Family
+	}{
+		{"203.0.113.1", IPv4, "203.0.113.1", IPv4},
+		{"203.0.113.1", IPv6, "203.0.113.1", IPv4},
+		{"2001:db8::1", IPv6, "2001:db8::1", IPv6},
+		{"2001:db8::1", IPv4, "2001:db8::1", IPv6},
+		{"not an IP", IPv6, "", IPv6},
+	}
+	for i, v := range tests {
+		srv := newIPServer(v.answer)
+		ip, f, err := GetPreferred(v.preferred, WithSources(srv.URL), WithDialContext(dial), WithMaxTries(1), WithTimeout(100*time.Millisecond))
+		srv.Close()
+		if v.expected == "" {
+			var dsErr *DualStackError
+			if !errors.As(err, &dsErr) || dsErr.IPv4 == nil || dsErr.IPv6 == nil {
+				t.Errorf("Error on case %d: expected a failure of both families, got %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error on case %d: unexpected error %s", i, err)
+			continue
+		}
+		if ip.String() != v.expected || f != v.family {
+			t.Errorf("Error on case %d: %s %s(actual) != %s %s(expected)", i, ip, f, v.expected, v.family)
+		}
+	}
+}
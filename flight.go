@@ -0,0 +1,166 @@
+package pubip
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+	"golang.org/x/sync/singleflight"
+)
+
+// flights shares the lookups running at the same time with the same settings,
+// so that concurrent calls don't query the services several times.
+var flights singleflight.Group
+
+// waiters counts the callers waiting for the shared lookup of each key, so
+// that it's canceled once none is left.
+var waiters = struct {
+	sync.Mutex
+	m map[string]*flightWaiters
+}{m: map[string]*flightWaiters{}}
+
+type flightWaiters struct {
+	// ctx is the context of the shared lookup. It keeps the values of the
+	// context of the caller which started it, not its cancellation.
+	ctx    context.Context
+	cancel context.CancelFunc
+	n      int
+}
+
+// join counts a caller waiting for the shared lookup of key.
+func join(ctx context.Context, key string) *flightWaiters {
+	waiters.Lock()
+	defer waiters.Unlock()
+	w := waiters.m[key]
+	if w == nil {
+		w = &flightWaiters{}
+		w.ctx, w.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		waiters.m[key] = w
+	}
+	w.n++
+	return w
+}
+
+// leave stops counting a caller of the shared lookup of key, and cancels the
+// lookup if it was the last one. The next callers then start another lookup
+// instead of joining the canceled one.
+func (w *flightWaiters) leave(key string) {
+	waiters.Lock()
+	defer waiters.Unlock()
+	w.n--
+	if w.n > 0 {
+		return
+	}
+	w.cancel()
+	delete(waiters.m, key)
+	flights.Forget(key)
+}
+
+// flightKey identifies the settings which change the outcome of a lookup.
+func (c *Client) flightKey(f Family) string {
+	// The pointers nested in the slice are printed as addresses, so the
+	// values which are often built for each lookup are spelled out.
+	var local string
+	if c.LocalAddr != nil {
+		local = c.LocalAddr.String()
+	}
+	var auth proxy.Auth
+	if c.SOCKS5Auth != nil {
+		auth = *c.SOCKS5Auth
+	}
+	var networks []string
+	for _, n := range c.ExpectedNetworks {
+		networks = append(networks, n.String())
+	}
+	// Functions cannot be compared, so the lookups of a client with its own
+	// DialContext or Validator are only shared with the ones of the same
+	// client. The lookups are only shared with the ones reported to the same
+	// Logger and Metrics, so that none goes unreported.
+	logger, okLogger := identity(c.Logger)
+	metrics, okMetrics := identity(c.Metrics)
+	srcs, okSources := sourceIdentities(c.sources())
+	var funcs *Client
+	if c.DialContext != nil || c.Validator != nil || !okLogger || !okMetrics || !okSources {
+		funcs = c
+	}
+	return fmt.Sprintf("%#v", []interface{}{
+		f, srcs, c.MaxTries, c.BackoffMin, c.BackoffMax,
+		c.BackoffFactor, c.BackoffJitter, c.DisableBackoff, c.Quorum,
+		c.Consensus, c.MixedFamily, c.Weights, c.MinWeight, c.SampleSize, c.BreakerThreshold,
+		c.BreakerCooldown, c.Timeout, c.RequestTimeout, c.TotalTimeout,
+		c.DialTimeout, c.TLSHandshakeTimeout, c.ResponseHeaderTimeout,
+		c.MaxRedirects, c.MaxBodySize, c.UserAgent, c.Headers, c.LenientParsing, networks, c.AllowPrivate,
+		funcs, logger, metrics, local, c.SOCKS5, auth, c.TLSConfig, c.httpClient(),
+	})
+}
+
+// identity returns what tells v apart from the other values of its interface
+// in a flight key: the address of a pointer, or else v itself. It reports
+// false when v cannot be compared.
+func identity(v interface{}) (interface{}, bool) {
+	if v == nil {
+		return nil, true
+	}
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		return fmt.Sprintf("(%T)(%p)", v, v), true
+	}
+	return v, t.Comparable()
+}
+
+// httpSourceKey spells out an HTTPSource in a flight key, with the name of its
+// Parse function.
+type httpSourceKey struct {
+	URL, Parse, ResponseHeader string
+	Headers                    map[string][]string
+	Username, Password         string
+	Method, Body               string
+	Timeout                    time.Duration
+}
+
+// sourceIdentities returns what tells the services apart in a flight key. It
+// reports false when one of them cannot be told apart from others, such as an
+// HTTPSource parsing with a closure of ParseJSONPath, whose code is the same
+// whatever the path.
+func sourceIdentities(srcs []Source) ([]interface{}, bool) {
+	ids := make([]interface{}, 0, len(srcs))
+	for _, s := range srcs {
+		hs, ok := s.(HTTPSource)
+		if !ok {
+			id, ok := identity(s)
+			if !ok {
+				return nil, false
+			}
+			ids = append(ids, id)
+			continue
+		}
+		parse, ok := parseName(hs.Parse)
+		if !ok {
+			return nil, false
+		}
+		ids = append(ids, httpSourceKey{
+			URL: hs.URL, Parse: parse, ResponseHeader: hs.ResponseHeader,
+			Headers: hs.Headers, Username: hs.Username, Password: hs.Password,
+			Method: hs.Method, Body: hs.Body, Timeout: hs.Timeout,
+		})
+	}
+	return ids, true
+}
+
+// parseName returns the name of the ParseFunc of this package p is, empty for
+// nil. It reports false for the other functions.
+func parseName(p ParseFunc) (string, bool) {
+	if p == nil {
+		return "", true
+	}
+	ptr := reflect.ValueOf(p).Pointer()
+	for name, known := range map[string]ParseFunc{"ParseText": ParseText, "ParseJSON": ParseJSON} {
+		if ptr == reflect.ValueOf(known).Pointer() {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,129 @@
+package pubip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrentGetsAreShared(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		// The lookup ends once the service answered, so it must last until
+		// the others join it.
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer srv.Close()
+	other := newIPServer("203.0.113.1")
+	defer other.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := Get(WithSources(srv.URL), WithTimeout(200*time.Millisecond)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	// Another source set must not share the lookup above.
+	if _, err := Get(WithSources(other.URL), WithTimeout(100*time.Millisecond)); err != nil {
+		t.Error(err)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("Expected a single request for concurrent lookups, got %d", n)
+	}
+}
+
+func TestFlightKey(t *testing.T) {
+	l1, l2 := &testLogger{}, &testLogger{}
+	m := &testMetrics{}
+	tests := []struct {
+		a, b  *Client
+		equal bool
+	}{
+		{NewClient(), NewClient(), true},
+		{NewClient(WithLocalAddr(&net.IPAddr{IP: net.IPv4(127, 0, 0, 1)})), NewClient(WithLocalAddr(&net.IPAddr{IP: net.IPv4(127, 0, 0, 1)})), true},
+		{NewClient(WithUserAgent("a b")), NewClient(WithUserAgent("a"), WithHeader("b", "")), false},
+		{NewClient(), NewClient(WithWeight("https://api.ipify.org", 2)), false},
+		{NewClient(WithExtraSources(HTTPSource{URL: "http://a", Parse: ParseJSON})), NewClient(WithExtraSources(HTTPSource{URL: "http://a", Parse: ParseJSON})), true},
+		{NewClient(WithExtraSources(HTTPSource{URL: "http://a", Parse: ParseJSON})), NewClient(WithExtraSources(HTTPSource{URL: "http://a"})), false},
+		// Closures of the same code cannot be told apart.
+		{NewClient(WithExtraSources(HTTPSource{URL: "http://a", Parse: ParseJSONPath("a")})), NewClient(WithExtraSources(HTTPSource{URL: "http://a", Parse: ParseJSONPath("b")})), false},
+		{NewClient(WithExtraSources(HTTPSource{URL: "http://a", Headers: http.Header{"A": {"1"}}})), NewClient(WithExtraSources(HTTPSource{URL: "http://a", Headers: http.Header{"A": {"2"}}})), false},
+		// The lookups are reported to the Logger and Metrics of all the clients.
+		{NewClient(WithLogger(l1)), NewClient(WithLogger(l1)), true},
+		{NewClient(WithLogger(l1)), NewClient(WithLogger(l2)), false},
+		{NewClient(), NewClient(WithMetrics(m)), false},
+		{NewClient(WithMetrics(m)), NewClient(WithMetrics(m)), true},
+	}
+	for i, v := range tests {
+		if equal := v.a.flightKey(anyFamily) == v.b.flightKey(anyFamily); equal != v.equal {
+			t.Errorf("Error on case %d: %t(actual) != %t(expected)", i, equal, v.equal)
+		}
+	}
+}
+
+func TestParseClosuresAreNotShared(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"a":"203.0.113.1","b":"203.0.113.2"}`))
+	}))
+	defer srv.Close()
+
+	for i, v := range []struct{ path, expected string }{{"a", "203.0.113.1"}, {"b", "203.0.113.2"}} {
+		src := HTTPSource{URL: srv.URL, Parse: ParseJSONPath(v.path)}
+		ip, err := Get(WithSources(), WithExtraSources(src), WithCacheTTL(time.Hour))
+		if err != nil {
+			t.Errorf("Error on case %d: %s", i, err)
+			continue
+		}
+		if ip.String() != v.expected {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, ip, v.expected)
+		}
+	}
+}
+
+func TestSharedLookupCanceledByLastCaller(t *testing.T) {
+	canceled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(canceled)
+	}))
+	defer srv.Close()
+
+	c := NewClient(WithSources(srv.URL), WithTimeout(5*time.Second), WithMaxTries(1))
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() { _, err := c.GetContext(ctx1); errs <- err }()
+	go func() { _, err := c.GetContext(ctx2); errs <- err }()
+	time.Sleep(50 * time.Millisecond)
+
+	cancel1()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("%v(actual) != %v(expected)", err, context.Canceled)
+	}
+	select {
+	case <-canceled:
+		t.Fatal("Expected the lookup to go on for the other caller")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel2()
+	<-errs
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the lookup to be canceled once no caller is left")
+	}
+}
@@ -0,0 +1,93 @@
+package pubip
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Logger receives the events of the lookups. Debugf is called for requests,
+// retries, answers and consensus, Warnf for failures of the services and of the
+// consensus.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// Metrics receives the measurements of the lookups, for instance to export
+// them to a monitoring system. Its methods are called concurrently.
+type Metrics interface {
+	// ObserveSource is called once a service answered, with a nil err, or
+	// failed, after d.
+	ObserveSource(source string, err error, d time.Duration)
+	// ObserveLookup is called once a lookup succeeded, with a nil err, or
+	// failed, after d. The results served from the cache are not lookups.
+	ObserveLookup(err error, d time.Duration)
+}
+
+// RetryMetrics is implemented by the Metrics which also count the retries of
+// the services, such as to alert when they creep up.
+type RetryMetrics interface {
+	// ObserveRetries is called once a service answered or failed, with the
+	// amount of retries it needed, zero when the first try succeeded.
+	ObserveRetries(source string, retries int)
+}
+
+func (c *Client) observeSource(source string, err error, d time.Duration) {
+	if c.Metrics != nil {
+		c.Metrics.ObserveSource(source, err, d)
+	}
+}
+
+func (c *Client) observeLookup(err error, d time.Duration) {
+	if c.Metrics != nil {
+		c.Metrics.ObserveLookup(err, d)
+	}
+}
+
+func (c *Client) observeRetries(source string, retries int) {
+	if m, ok := c.Metrics.(RetryMetrics); ok {
+		m.ObserveRetries(source, retries)
+	}
+}
+
+func (c *Client) debugf(format string, args ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Debugf(format, args...)
+	}
+}
+
+func (c *Client) warnf(format string, args ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Warnf(format, args...)
+	}
+}
+
+// sensitive tells whether the value of the header must not be logged.
+func sensitive(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Proxy-Authorization", "Cookie":
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, s := range []string{"key", "token", "secret", "password"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redacted returns a copy of h without the values of the sensitive headers,
+// to be logged.
+func redacted(h http.Header) http.Header {
+	r := h.Clone()
+	for k, vs := range r {
+		if sensitive(k) {
+			for i := range vs {
+				vs[i] = "REDACTED"
+			}
+		}
+	}
+	return r
+}
@@ -0,0 +1,124 @@
+package pubip
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Debugf(format string, args ...interface{}) {
+	l.log("DEBUG "+format, args...)
+}
+
+func (l *testLogger) Warnf(format string, args ...interface{}) {
+	l.log("WARN "+format, args...)
+}
+
+func (l *testLogger) log(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestLogger(t *testing.T) {
+	good := newIPServer("203.0.113.1")
+	defer good.Close()
+	bad := newIPServer("not an IP")
+	defer bad.Close()
+
+	l := &testLogger{}
+	if _, err := Get(WithSources(good.URL, bad.URL), WithQuorum(1), WithTimeout(100*time.Millisecond), WithLogger(l)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	log := strings.Join(l.lines, "\n")
+	for _, expected := range []string{
+		"DEBUG " + good.URL + " answered 203.0.113.1",
+		"WARN " + bad.URL + " failed: IP address not valid",
+		"DEBUG Consensus on 203.0.113.1 among 1 results",
+	} {
+		if !strings.Contains(log, expected) {
+			t.Errorf("Expected %q in the log:\n%s", expected, log)
+		}
+	}
+}
+
+type testMetrics struct {
+	mu      sync.Mutex
+	sources map[string]error
+	lookups []error
+	retries map[string]int
+}
+
+func (m *testMetrics) ObserveSource(source string, err error, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sources[source] = err
+}
+
+func (m *testMetrics) ObserveLookup(err error, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lookups = append(m.lookups, err)
+}
+
+func (m *testMetrics) ObserveRetries(source string, retries int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries[source] = retries
+}
+
+func TestMetrics(t *testing.T) {
+	good := newIPServer("203.0.113.1")
+	defer good.Close()
+	bad := newIPServer("not an IP")
+	defer bad.Close()
+
+	m := &testMetrics{sources: map[string]error{}, retries: map[string]int{}}
+	c := NewClient(WithSources(good.URL, bad.URL), WithQuorum(1), WithTimeout(100*time.Millisecond), WithMetrics(m), WithCacheTTL(time.Hour))
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get(); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	if len(m.sources) != 2 || m.sources[good.URL] != nil || m.sources[bad.URL] == nil {
+		t.Errorf("Unexpected measurements of the services: %v", m.sources)
+	}
+	if len(m.lookups) != 1 || m.lookups[0] != nil {
+		t.Errorf("Expected a single successful lookup, got %v", m.lookups)
+	}
+}
+
+func TestRetryMetrics(t *testing.T) {
+	good := newIPServer("203.0.113.1")
+	defer good.Close()
+	var hits int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer flaky.Close()
+
+	m := &testMetrics{sources: map[string]error{}, retries: map[string]int{}}
+	_, err := Get(WithSources(good.URL, flaky.URL), WithQuorum(2), WithBackoff(time.Millisecond, time.Millisecond, 1), WithMetrics(m))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := map[string]int{good.URL: 0, flaky.URL: 2}
+	if !reflect.DeepEqual(m.retries, expected) {
+		t.Errorf("%v(actual) != %v(expected)", m.retries, expected)
+	}
+}
@@ -0,0 +1,59 @@
+package pubip
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// natProbes is the public addresses whose route tells the local IP address of
+// each family. Nothing is sent to them.
+var natProbes = map[Family]string{
+	IPv4: "8.8.8.8:53",
+	IPv6: "[2001:4860:4860::8888]:53",
+}
+
+// BehindNAT tells whether this machine is behind NAT, by comparing the local
+// IP address it reaches the Internet from with its public IP address. The
+// local address is the one of the family of the public address, so that a
+// dual-stack machine is compared with the right one.
+func BehindNAT(opts ...Option) (nat bool, local, public net.IP, err error) {
+	return NewClient(opts...).BehindNAT()
+}
+
+// BehindNAT tells whether this machine is behind NAT, by comparing the local
+// IP address it reaches the Internet from with its public IP address. The
+// local address is the one of the family of the public address, so that a
+// dual-stack machine is compared with the right one.
+func (c *Client) BehindNAT() (nat bool, local, public net.IP, err error) {
+	public, err = c.Get()
+	if err != nil {
+		return false, nil, nil, err
+	}
+	local, err = c.outboundIP(familyOf(public))
+	if err != nil {
+		return false, nil, public, err
+	}
+	return !local.Equal(public), local, public, nil
+}
+
+// outboundIP returns the local IP address of family f that the default route,
+// or LocalAddr, goes through. No packet is sent.
+func (c *Client) outboundIP(f Family) (net.IP, error) {
+	conn, err := c.dial()(context.Background(), f.network("udp"), natProbes[f])
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// A DialContext may return a connection of another kind, such as one
+	// wrapped by a proxy.
+	switch a := conn.LocalAddr().(type) {
+	case *net.UDPAddr:
+		return a.IP, nil
+	case *net.TCPAddr:
+		return a.IP, nil
+	case *net.IPAddr:
+		return a.IP, nil
+	}
+	return nil, fmt.Errorf("Unexpected local address %q of the connection", conn.LocalAddr())
+}
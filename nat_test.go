@@ -0,0 +1,51 @@
+package pubip
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBehindNAT(t *testing.T) {
+	probes := natProbes
+	natProbes = map[Family]string{IPv4: "127.0.0.1:9"}
+	defer func() { natProbes = probes }()
+
+	tests := []struct {
+		public string
+		nat    bool
+	}{
+		{"127.0.0.1", false},
+		{"203.0.113.1", true},
+	}
+	for i, v := range tests {
+		srv := newIPServer(v.public)
+		defer srv.Close()
+
+		nat, local, public, err := BehindNAT(WithSources(srv.URL), WithTimeout(50*time.Millisecond), WithAllowPrivate(true))
+		if err != nil {
+			t.Errorf("Error on case %d: %s", i, err)
+			continue
+		}
+		if nat != v.nat {
+			t.Errorf("Error on case %d: %t(actual) != %t(expected)", i, nat, v.nat)
+		}
+		if !local.Equal(net.ParseIP("127.0.0.1")) {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, local, "127.0.0.1")
+		}
+		if !public.Equal(net.ParseIP(v.public)) {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, public, v.public)
+		}
+	}
+}
+
+func TestOutboundIPWithoutLocalAddr(t *testing.T) {
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, _ := net.Pipe()
+		return c, nil
+	}
+	if _, err := NewClient(WithDialContext(dial)).outboundIP(IPv4); err == nil {
+		t.Error("Expected an error for a connection without a local IP address")
+	}
+}
@@ -0,0 +1,74 @@
+package pubip
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// networkPollInterval is how often the addresses of the interfaces are
+// compared when the system cannot notify of their changes.
+const networkPollInterval = 5 * time.Second
+
+// networkSettle is how long a lookup waits after a change of the network, so
+// that the burst of changes of a new connection is over and the new address
+// is routable.
+const networkSettle = 500 * time.Millisecond
+
+// interfaceAddrs returns the addresses of the interfaces of this machine.
+var interfaceAddrs = net.InterfaceAddrs
+
+// networkChanges returns a channel receiving a value when an interface of this
+// machine gains or loses an address, or the routes change, until ctx is done.
+// The changes are notified by the system, such as netlink on Linux, or else
+// found by comparing the addresses of the interfaces every
+// networkPollInterval.
+var networkChanges = func(ctx context.Context) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	if err := nativeNetworkChanges(ctx, notify); err != nil {
+		go pollNetworkChanges(ctx, networkPollInterval, notify)
+	}
+	return ch
+}
+
+// pollNetworkChanges calls notify whenever the addresses of the interfaces
+// differ from the ones of interval earlier, until ctx is done.
+func pollNetworkChanges(ctx context.Context, interval time.Duration, notify func()) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	last := addrSnapshot()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		if addrs := addrSnapshot(); !reflect.DeepEqual(addrs, last) {
+			last = addrs
+			notify()
+		}
+	}
+}
+
+// addrSnapshot returns the sorted addresses of the interfaces, nil if they
+// cannot be listed.
+func addrSnapshot() []string {
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	s := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		s = append(s, a.String())
+	}
+	sort.Strings(s)
+	return s
+}
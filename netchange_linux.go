@@ -0,0 +1,66 @@
+//go:build linux
+
+package pubip
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+)
+
+// The multicast groups of netlink, from linux/rtnetlink.h, which are not in
+// syscall.
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6IfAddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// nativeNetworkChanges calls notify for each change of the links, the
+// addresses and the routes reported by netlink, until ctx is done.
+func nativeNetworkChanges(ctx context.Context, notify func()) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+	sa := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr | rtmgrpIPv4Route | rtmgrpIPv6Route,
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return os.NewSyscallError("bind", err)
+	}
+	// A non-blocking socket is handed to the poller of the runtime, so that
+	// closing it interrupts the read.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return os.NewSyscallError("setnonblock", err)
+	}
+	f := os.NewFile(uintptr(fd), "netlink")
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	go func() {
+		buf := make([]byte, 1<<16)
+		for {
+			n, err := f.Read(buf)
+			if errors.Is(err, syscall.ENOBUFS) {
+				// Changes were dropped as they came faster than they were read.
+				notify()
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if n > 0 {
+				notify()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package pubip
+
+import (
+	"context"
+	"errors"
+)
+
+// nativeNetworkChanges fails, as the changes of the network are only notified
+// by the system on Linux. They are polled otherwise.
+func nativeNetworkChanges(ctx context.Context, notify func()) error {
+	return errors.New("No notification of the changes of the network on this system")
+}
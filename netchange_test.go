@@ -0,0 +1,78 @@
+package pubip
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPollNetworkChanges(t *testing.T) {
+	defer func(f func() ([]net.Addr, error)) { interfaceAddrs = f }(interfaceAddrs)
+	var addrs atomic.Value
+	addrs.Store("192.0.2.1/24")
+	interfaceAddrs = func() ([]net.Addr, error) {
+		_, n, _ := net.ParseCIDR(addrs.Load().(string))
+		return []net.Addr{n}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	notified := make(chan struct{}, 10)
+	go pollNetworkChanges(ctx, 5*time.Millisecond, func() { notified <- struct{}{} })
+
+	select {
+	case <-notified:
+		t.Fatal("Unexpected notification without any change")
+	case <-time.After(50 * time.Millisecond):
+	}
+	addrs.Store("198.51.100.1/24")
+	select {
+	case <-notified:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a notification of the change")
+	}
+}
+
+func TestNativeNetworkChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := nativeNetworkChanges(ctx, func() {}); err != nil {
+		t.Skipf("No notification of the changes of the network: %s", err)
+	}
+	cancel()
+}
+
+func TestWatchNetwork(t *testing.T) {
+	defer func(f func(context.Context) <-chan struct{}) { networkChanges = f }(networkChanges)
+	changes := make(chan struct{}, 1)
+	networkChanges = func(ctx context.Context) <-chan struct{} {
+		return changes
+	}
+	var answer atomic.Value
+	answer.Store("203.0.113.1")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(answer.Load().(string)))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ips, errs := Watch(ctx, time.Hour, WithSources(srv.URL), WithCacheTTL(time.Hour), WithWatchNetwork(true))
+	for i, expected := range []string{"203.0.113.1", "203.0.113.2"} {
+		answer.Store(expected)
+		changes <- struct{}{}
+		select {
+		case actual := <-ips:
+			if actual != expected {
+				t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, actual, expected)
+			}
+		case err := <-errs:
+			t.Errorf("Error on case %d: %s", i, err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Error on case %d: nothing received", i)
+		}
+	}
+}
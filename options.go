@@ -0,0 +1,362 @@
+package pubip
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Option configures a Client. Options are applied by NewClient on top of the
+// package level settings, so they never change the settings of other lookups.
+//
+// Usage:
+//
+//		ip, err := pubip.Get(pubip.WithSources("https://api.ipify.org"), pubip.WithQuorum(1))
+type Option func(*Client)
+
+// WithMaxTries sets the maximum amount of tries to attempt to one service.
+func WithMaxTries(n int) Option {
+	return func(c *Client) {
+		c.MaxTries = n
+	}
+}
+
+// WithSources sets the URIs of the services.
+func WithSources(uris ...string) Option {
+	return func(c *Client) {
+		c.APIURIs = append([]string(nil), uris...)
+	}
+}
+
+// WithExtraSources sets the other services, such as the ones answering in
+// JSON or over DNS, queried along with the URIs of the services.
+func WithExtraSources(srcs ...Source) Option {
+	return func(c *Client) {
+		c.Sources = append([]Source(nil), srcs...)
+	}
+}
+
+// WithBackoff sets the first and the longest pauses between tries to a
+// service, and what the pause is multiplied by after each failed try.
+func WithBackoff(min, max time.Duration, factor float64) Option {
+	return func(c *Client) {
+		c.BackoffMin = min
+		c.BackoffMax = max
+		c.BackoffFactor = factor
+	}
+}
+
+// WithBackoffJitter sets whether the pauses between tries to a service are
+// randomized.
+func WithBackoffJitter(jitter bool) Option {
+	return func(c *Client) {
+		c.BackoffJitter = jitter
+	}
+}
+
+// WithDisableBackoff sets whether the tries to a service follow each other
+// without any pause.
+func WithDisableBackoff(disable bool) Option {
+	return func(c *Client) {
+		c.DisableBackoff = disable
+	}
+}
+
+// WithQuorum sets the minimum amount of identical results required from the
+// services.
+func WithQuorum(n int) Option {
+	return func(c *Client) {
+		c.Quorum = n
+	}
+}
+
+// WithConsensus sets the strategy deciding which address wins among the
+// results.
+func WithConsensus(s ConsensusStrategy) Option {
+	return func(c *Client) {
+		c.Consensus = s
+	}
+}
+
+// WithMixedFamilyPolicy sets which address wins when the services answer
+// addresses of both families.
+func WithMixedFamilyPolicy(p MixedFamilyPolicy) Option {
+	return func(c *Client) {
+		c.MixedFamily = p
+	}
+}
+
+// WithValidator sets what decides which address wins among the outcomes of the
+// services, instead of Quorum and Consensus.
+func WithValidator(v Validator) Option {
+	return func(c *Client) {
+		c.Validator = v
+	}
+}
+
+// WithWeight sets the weight of a service in the Weighted consensus, by its
+// name: the String of its Source or its URI.
+func WithWeight(source string, weight float64) Option {
+	return func(c *Client) {
+		if c.Weights == nil {
+			c.Weights = map[string]float64{}
+		}
+		c.Weights[source] = weight
+	}
+}
+
+// WithMinWeight sets the minimum total weight of the services answering the
+// address which wins the Weighted consensus.
+func WithMinWeight(weight float64) Option {
+	return func(c *Client) {
+		c.MinWeight = weight
+	}
+}
+
+// WithMaxConcurrency sets the maximum amount of services queried at the same
+// time.
+func WithMaxConcurrency(n int) Option {
+	return func(c *Client) {
+		c.MaxConcurrency = n
+	}
+}
+
+// WithSampleSize sets the amount of services, picked at random, queried by
+// each lookup. It is raised to the quorum.
+func WithSampleSize(k int) Option {
+	return func(c *Client) {
+		c.SampleSize = k
+	}
+}
+
+// WithBreaker skips the services for cooldown after threshold consecutive
+// failures.
+func WithBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *Client) {
+		c.BreakerThreshold = threshold
+		c.BreakerCooldown = cooldown
+	}
+}
+
+// WithCacheTTL sets how long the result of a lookup is reused by the next ones
+// with the same settings.
+func WithCacheTTL(d time.Duration) Option {
+	return func(c *Client) {
+		c.CacheTTL = d
+	}
+}
+
+// WithStaleWhileRevalidate sets how long past CacheTTL a cached result is
+// still returned while the cache is refreshed in the background.
+func WithStaleWhileRevalidate(d time.Duration) Option {
+	return func(c *Client) {
+		c.StaleWhileRevalidate = d
+	}
+}
+
+// WithRateLimit sets the minimum time between two lookups querying the
+// services with the same settings, and whether a lookup arriving sooner waits
+// for its turn instead of failing with ErrRateLimited.
+func WithRateLimit(interval time.Duration, wait bool) Option {
+	return func(c *Client) {
+		c.MinInterval = interval
+		c.WaitRateLimit = wait
+	}
+}
+
+// WithTimeout sets the time limit of collecting results from different
+// services. The package level Timeout stays the default of the other lookups,
+// and the concurrent ones with different limits don't share their requests.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.Timeout = d
+	}
+}
+
+// WithRequestTimeout sets the time limit of each request to a service.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.RequestTimeout = d
+	}
+}
+
+// WithTotalTimeout sets the time limit of querying an HTTP service, all the
+// tries and the pauses between them included.
+func WithTotalTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.TotalTimeout = d
+	}
+}
+
+// WithMaxRedirects sets the maximum amount of redirects followed for a
+// request to a service.
+func WithMaxRedirects(n int) Option {
+	return func(c *Client) {
+		c.MaxRedirects = n
+	}
+}
+
+// WithMaxBodySize sets the maximum size in bytes of the answer of a service.
+func WithMaxBodySize(n int64) Option {
+	return func(c *Client) {
+		c.MaxBodySize = n
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent to the services.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) {
+		c.UserAgent = ua
+	}
+}
+
+// WithDialContext sets what connects to the services.
+func WithDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(c *Client) {
+		c.DialContext = dial
+	}
+}
+
+// WithLocalAddr sets the local address the services are queried from.
+func WithLocalAddr(addr net.Addr) Option {
+	return func(c *Client) {
+		c.LocalAddr = addr
+	}
+}
+
+// WithFallbackDelay sets how long a connection to a dual-stack service waits
+// for IPv6 before racing IPv4 too.
+func WithFallbackDelay(d time.Duration) Option {
+	return func(c *Client) {
+		c.FallbackDelay = d
+	}
+}
+
+// WithHeader adds a header sent to the services.
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		if c.Headers == nil {
+			c.Headers = http.Header{}
+		}
+		c.Headers.Add(key, value)
+	}
+}
+
+// WithSOCKS5 sets the SOCKS5 proxy the HTTP services are queried through,
+// authenticated with auth when not nil.
+func WithSOCKS5(addr string, auth *proxy.Auth) Option {
+	return func(c *Client) {
+		c.SOCKS5 = addr
+		c.SOCKS5Auth = auth
+	}
+}
+
+// WithTLSConfig sets the TLS settings the HTTP services are queried with.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		c.TLSConfig = config
+	}
+}
+
+// WithIdleConns sets the maximum amount of idle connections kept to each
+// service, and how long they are kept, so that the next lookups reuse them.
+func WithIdleConns(perHost int, timeout time.Duration) Option {
+	return func(c *Client) {
+		c.MaxIdleConnsPerHost = perHost
+		c.IdleConnTimeout = timeout
+	}
+}
+
+// WithTransportTimeouts sets the time limits of connecting to a service, of
+// the TLS handshake with it, and of waiting for the headers of its answer.
+func WithTransportTimeouts(dial, tlsHandshake, responseHeader time.Duration) Option {
+	return func(c *Client) {
+		c.DialTimeout = dial
+		c.TLSHandshakeTimeout = tlsHandshake
+		c.ResponseHeaderTimeout = responseHeader
+	}
+}
+
+// WithDisableHTTP2 sets whether the HTTP services are only queried over
+// HTTP/1.1.
+func WithDisableHTTP2(disable bool) Option {
+	return func(c *Client) {
+		c.DisableHTTP2 = disable
+	}
+}
+
+// WithLenientParsing sets whether the first IP address among the words of the
+// answer of a service is accepted, when the answer isn't only the address.
+func WithLenientParsing(lenient bool) Option {
+	return func(c *Client) {
+		c.LenientParsing = lenient
+	}
+}
+
+// WithExpectedCIDRs sets the networks, in CIDR notation such as
+// "203.0.113.0/24", the address agreed on by the services must belong to. If
+// one of them is malformed, Validate and the lookups fail.
+func WithExpectedCIDRs(cidrs ...string) Option {
+	return func(c *Client) {
+		c.ExpectedNetworks = nil
+		c.networksErr = nil
+		for _, cidr := range cidrs {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				c.networksErr = fmt.Errorf("Invalid expected CIDR: %w", err)
+				return
+			}
+			c.ExpectedNetworks = append(c.ExpectedNetworks, n)
+		}
+	}
+}
+
+// WithAllowPrivate sets whether answers which are not public addresses, such
+// as private, loopback or link-local ones, are accepted.
+func WithAllowPrivate(allow bool) Option {
+	return func(c *Client) {
+		c.AllowPrivate = allow
+	}
+}
+
+// WithStablePolls sets the amount of consecutive lookups of Watch and
+// OnChange which must find a new address before it is reported.
+func WithStablePolls(n int) Option {
+	return func(c *Client) {
+		c.StablePolls = n
+	}
+}
+
+// WithWatchNetwork sets whether Watch and OnChange look up as soon as the
+// network of this machine changes.
+func WithWatchNetwork(watch bool) Option {
+	return func(c *Client) {
+		c.WatchNetwork = watch
+	}
+}
+
+// WithLogger sets the logger receiving the events of the lookups.
+func WithLogger(l Logger) Option {
+	return func(c *Client) {
+		c.Logger = l
+	}
+}
+
+// WithMetrics sets what receives the measurements of the lookups.
+func WithMetrics(m Metrics) Option {
+	return func(c *Client) {
+		c.Metrics = m
+	}
+}
+
+// WithHTTPClient sets the client used to query the services.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.HTTPClient = hc
+	}
+}
@@ -0,0 +1,92 @@
+package pubip
+
+import (
+	"net/http"
+	"time"
+)
+
+// LookupPlan describes what a lookup would do with the settings of a Client.
+type LookupPlan struct {
+	// Sources is the services the lookup would query, without the
+	// duplicates, in order. Only SampleSize of them, picked at random, are
+	// queried when it's not zero.
+	Sources []PlannedSource
+	// SampleSize is the effective SampleSize, raised to Quorum: the amount of
+	// Sources picked at random by each lookup, zero when all of them are
+	// queried.
+	SampleSize int
+	// Quorum is the effective Quorum, clamped to the amount of services.
+	Quorum int
+	// Consensus is the strategy deciding which address wins, unless
+	// Validator is set.
+	Consensus ConsensusStrategy
+	// MixedFamily decides which address wins when the services answer
+	// addresses of both families.
+	MixedFamily MixedFamilyPolicy
+	// Validator tells whether a Validator decides instead of Quorum and
+	// Consensus.
+	Validator bool
+	// MaxTries is the maximum amount of tries to each service.
+	MaxTries int
+	// Timeout is the time limit of collecting the results.
+	Timeout time.Duration
+	// Cached tells whether the lookup would be answered by the cache.
+	Cached bool
+}
+
+// PlannedSource describes how a lookup would query a service.
+type PlannedSource struct {
+	// Source is the URI of the service.
+	Source string
+	// Method is the HTTP method of the requests to an HTTP service. It's
+	// empty for the other services.
+	Method string
+	// Timeout is the time limit of each request to the service, zero for no
+	// limit other than the one of HTTPClient.
+	Timeout time.Duration
+	// Headers is the headers sent to an HTTP service, with the values of the
+	// ones holding credentials replaced. It's nil for the other services.
+	Headers http.Header
+	// Skipped tells whether the circuit breaker would skip the service.
+	Skipped bool
+}
+
+// Plan describes what Get would do with the opts, without querying anything.
+func Plan(opts ...Option) LookupPlan {
+	return NewClient(opts...).Plan()
+}
+
+// Plan describes what Get would do, without querying anything nor changing
+// any state, such as the circuit breaker.
+func (c *Client) Plan() LookupPlan {
+	p := LookupPlan{
+		Quorum:      c.quorum(),
+		Consensus:   c.Consensus,
+		MixedFamily: c.MixedFamily,
+		Validator:   c.Validator != nil,
+		MaxTries:    c.MaxTries,
+		Timeout:     c.Timeout,
+	}
+	if c.CacheTTL > 0 {
+		_, p.Cached = cached(c.flightKey(anyFamily), c.CacheTTL+c.StaleWhileRevalidate)
+	}
+	srcs := c.sources()
+	p.SampleSize = c.sampleSize(len(srcs))
+	for _, s := range srcs {
+		ps := PlannedSource{Source: s.String(), Timeout: c.RequestTimeout, Skipped: c.skipped(s.String())}
+		switch s := s.(type) {
+		case HTTPSource:
+			ps.Method = s.method()
+			ps.Timeout = c.requestTimeout(s.Timeout)
+			if _, user, err := s.target(); err == nil {
+				ps.Headers = redacted(c.header(s, user))
+			}
+		case DNSSource:
+			ps.Timeout = c.requestTimeout(s.Timeout)
+		case STUNSource:
+			ps.Timeout = c.requestTimeout(s.Timeout)
+		}
+		p.Sources = append(p.Sources, ps)
+	}
+	return p
+}
@@ -0,0 +1,114 @@
+// Package prometheus exports the measurements of the lookups of pubip as
+// Prometheus metrics.
+//
+// Usage, with promclient being github.com/prometheus/client_golang/prometheus:
+//
+//		m := prometheus.New("myapp")
+//		promclient.MustRegister(m)
+//		ip, err := pubip.Get(pubip.WithMetrics(m))
+package prometheus
+
+import (
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics is a pubip.Metrics and a prometheus.Collector of:
+//
+//   - pubip_lookups_total, the lookups by outcome
+//   - pubip_lookup_duration_seconds, the duration of the lookups
+//   - pubip_source_requests_total, the lookups of each service by outcome
+//   - pubip_source_duration_seconds, the duration of the lookups of each
+//     service
+//   - pubip_source_retries, the retries of the lookups of each service
+//
+// The outcome is either "success" or "failure".
+type Metrics struct {
+	lookups        *prom.CounterVec
+	lookupDuration prom.Histogram
+	sources        *prom.CounterVec
+	sourceDuration *prom.HistogramVec
+	sourceRetries  *prom.HistogramVec
+}
+
+// New returns the metrics, named after namespace when not empty.
+func New(namespace string) *Metrics {
+	return &Metrics{
+		lookups: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "pubip",
+			Name:      "lookups_total",
+			Help:      "Lookups of the public IP address by outcome.",
+		}, []string{"outcome"}),
+		lookupDuration: prom.NewHistogram(prom.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "pubip",
+			Name:      "lookup_duration_seconds",
+			Help:      "Duration of the lookups of the public IP address.",
+			Buckets:   prom.DefBuckets,
+		}),
+		sources: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "pubip",
+			Name:      "source_requests_total",
+			Help:      "Lookups of each service by outcome.",
+		}, []string{"source", "outcome"}),
+		sourceDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "pubip",
+			Name:      "source_duration_seconds",
+			Help:      "Duration of the lookups of each service.",
+			Buckets:   prom.DefBuckets,
+		}, []string{"source"}),
+		sourceRetries: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "pubip",
+			Name:      "source_retries",
+			Help:      "Retries of the lookups of each service.",
+			Buckets:   prom.LinearBuckets(0, 1, 5),
+		}, []string{"source"}),
+	}
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// ObserveSource implements pubip.Metrics.
+func (m *Metrics) ObserveSource(source string, err error, d time.Duration) {
+	m.sources.WithLabelValues(source, outcome(err)).Inc()
+	m.sourceDuration.WithLabelValues(source).Observe(d.Seconds())
+}
+
+// ObserveLookup implements pubip.Metrics.
+func (m *Metrics) ObserveLookup(err error, d time.Duration) {
+	m.lookups.WithLabelValues(outcome(err)).Inc()
+	m.lookupDuration.Observe(d.Seconds())
+}
+
+// ObserveRetries implements pubip.RetryMetrics.
+func (m *Metrics) ObserveRetries(source string, retries int) {
+	m.sourceRetries.WithLabelValues(source).Observe(float64(retries))
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prom.Desc) {
+	m.lookups.Describe(ch)
+	m.lookupDuration.Describe(ch)
+	m.sources.Describe(ch)
+	m.sourceDuration.Describe(ch)
+	m.sourceRetries.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prom.Metric) {
+	m.lookups.Collect(ch)
+	m.lookupDuration.Collect(ch)
+	m.sources.Collect(ch)
+	m.sourceDuration.Collect(ch)
+	m.sourceRetries.Collect(ch)
+}
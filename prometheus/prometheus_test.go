@@ -0,0 +1,49 @@
+package prometheus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chyeh/pubip"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var (
+	_ pubip.Metrics      = (*Metrics)(nil)
+	_ pubip.RetryMetrics = (*Metrics)(nil)
+)
+
+func TestMetrics(t *testing.T) {
+	m := New("")
+	r := prom.NewPedanticRegistry()
+	if err := r.Register(m); err != nil {
+		t.Fatal(err)
+	}
+
+	m.ObserveSource("https://api.ipify.org", nil, 10*time.Millisecond)
+	m.ObserveSource("https://api.ipify.org", errors.New("Timeout"), time.Second)
+	m.ObserveLookup(nil, time.Second)
+	m.ObserveRetries("https://api.ipify.org", 2)
+
+	expected := `
+# HELP pubip_lookups_total Lookups of the public IP address by outcome.
+# TYPE pubip_lookups_total counter
+pubip_lookups_total{outcome="success"} 1
+# HELP pubip_source_requests_total Lookups of each service by outcome.
+# TYPE pubip_source_requests_total counter
+pubip_source_requests_total{outcome="failure",source="https://api.ipify.org"} 1
+pubip_source_requests_total{outcome="success",source="https://api.ipify.org"} 1
+`
+	if err := testutil.GatherAndCompare(r, strings.NewReader(expected), "pubip_lookups_total", "pubip_source_requests_total"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(m, "pubip_source_duration_seconds"); n != 1 {
+		t.Errorf("%d(actual) != %d(expected) histograms", n, 1)
+	}
+	if n := testutil.CollectAndCount(m, "pubip_source_retries"); n != 1 {
+		t.Errorf("%d(actual) != %d(expected) histograms", n, 1)
+	}
+}
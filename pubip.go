@@ -2,17 +2,28 @@
 package pubip
 
 import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"net"
 	"net/http"
-	"reflect"
+	"net/url"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/jpillora/backoff"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // GetIPBy queries an API to retrieve a `net.IP` of this machine's public IP
@@ -35,45 +46,228 @@ import (
 //				fmt.Println("My IP address is:", ip)
 //			}
 //		}
-func GetIPBy(dest string) (net.IP, error) {
+func GetIPBy(dest string, opts ...Option) (net.IP, error) {
+	return NewClient(opts...).GetIPBy(dest)
+}
+
+// GetIPByContext is like GetIPBy but carries a context. The context is
+// attached to every request and also interrupts the backoff between retries,
+// in which case ctx.Err() is returned.
+func GetIPByContext(ctx context.Context, dest string, opts ...Option) (net.IP, error) {
+	return NewClient(opts...).GetIPByContext(ctx, dest)
+}
+
+// GetIPByDetailed is like GetIPBy but also tells how long the service took to
+// answer and how many retries it needed.
+func GetIPByDetailed(dest string, opts ...Option) (Result, error) {
+	return NewClient(opts...).GetIPByDetailed(dest)
+}
+
+// getIPBy queries s, retrying the transient failures, and also returns the
+// amount of retries, zero when the first try succeeded.
+func (c *Client) getIPBy(ctx context.Context, s HTTPSource, f Family) (ip net.IP, retries int, err error) {
+	dest, user, err := s.target()
+	if err != nil {
+		return nil, retries, err
+	}
+	parent := ctx
+	if c.TotalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.TotalTimeout)
+		defer cancel()
+	}
 	b := &backoff.Backoff{
-		Jitter: true,
+		Min:    c.BackoffMin,
+		Max:    c.BackoffMax,
+		Factor: c.BackoffFactor,
+		Jitter: c.BackoffJitter,
+	}
+	client := c.limitRedirects(c.dialClient(f))
+	if socket := s.socket(); socket != "" {
+		client = c.limitRedirects(c.unixClient(socket))
 	}
-	client := &http.Client{}
 
-	req, err := http.NewRequest("GET", dest, nil)
+	var reqBody io.Reader
+	if s.Body != "" {
+		reqBody = strings.NewReader(s.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, s.method(), dest, reqBody)
 	if err != nil {
-		return nil, err
+		return nil, retries, err
 	}
+	req.Header = c.header(s, user)
+	c.debugf("Querying %s with %v", dest, redacted(req.Header))
 
-	for tries := 0; tries < MaxTries; tries++ {
-		resp, err := client.Do(req)
-		if err != nil {
-			d := b.Duration()
-			time.Sleep(d)
-			continue
+	span := trace.SpanFromContext(ctx)
+	var lastErr error
+	var d time.Duration
+	// interrupted returns the failure of the last try when TotalTimeout
+	// elapsed, or else the error of the context.
+	interrupted := func() error {
+		if parent.Err() != nil || lastErr == nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("Failed to reach %s within %s: %w", dest, c.TotalTimeout, lastErr)
+	}
+	for tries := 0; tries < c.MaxTries; tries++ {
+		retries = tries
+		if tries > 0 {
+			span.SetAttributes(attribute.Int("pubip.retries", tries))
+			if c.DisableBackoff {
+				d = 0
+			}
+			c.debugf("%s failed: %s, backing off %s", dest, lastErr, d)
+			if err := sleep(ctx, d); err != nil {
+				return nil, retries, interrupted()
+			}
 		}
 
-		defer resp.Body.Close()
-
-		body, err := ioutil.ReadAll(resp.Body)
+		if tries > 0 && req.GetBody != nil {
+			// The body was read by the previous try.
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, retries, err
+			}
+		}
+		resp, body, err := c.do(client, req, s.Timeout)
 		if err != nil {
-			return nil, err
+			if ctx.Err() != nil {
+				return nil, retries, interrupted()
+			}
+			if !transient(err) {
+				return nil, retries, fmt.Errorf("Failed to reach %s: %w", dest, err)
+			}
+			lastErr, d = err, b.Duration()
+			continue
 		}
 
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr, d = c.statusErr(dest, resp, body), b.Duration()
+			if ra := retryAfter(resp.Header); ra > d {
+				d = ra
+				if longest := c.maxRetryAfter(); d > longest {
+					d = longest
+				}
+			}
+			continue
+		}
 		if resp.StatusCode != 200 {
-			return nil, errors.New(dest + " status code " + strconv.Itoa(resp.StatusCode) + ", body: " + string(body))
+			return nil, retries, c.statusErr(dest, resp, body)
+		}
+		var tb string
+		if s.ResponseHeader != "" {
+			v := resp.Header.Get(s.ResponseHeader)
+			if v == "" {
+				return nil, retries, &ParseError{Body: body, Err: fmt.Errorf("No %s header in the answer of %s", s.ResponseHeader, dest)}
+			}
+			tb, _, _ = strings.Cut(v, ",")
+			tb = strings.TrimSpace(tb)
+		} else {
+			if max := c.maxBodySize(); int64(len(body)) > max {
+				return nil, retries, fmt.Errorf("Body of %s longer than %d bytes", dest, max)
+			}
+			if tb, err = s.parse(body); err != nil {
+				return nil, retries, &ParseError{Body: body, Err: err}
+			}
+		}
+		ip = parseIP(tb)
+		if ip == nil && c.LenientParsing {
+			ip = firstIP(tb)
 		}
-
-		tb := strings.TrimSpace(string(body))
-		ip := net.ParseIP(tb)
 		if ip == nil {
-			return nil, errors.New("IP address not valid: " + tb)
+			return nil, retries, &ParseError{Body: body, Value: tb}
 		}
-		return ip, nil
+		return ip, retries, nil
+	}
+
+	if lastErr != nil {
+		return nil, retries, fmt.Errorf("Failed to reach %s: %w", dest, lastErr)
 	}
+	return nil, retries, errors.New("Failed to reach " + dest)
+}
 
-	return nil, errors.New("Failed to reach " + dest)
+// header returns the headers sent to s: User-Agent, the basic auth of user
+// when not nil, then Headers and the ones of s, each replacing the previous
+// ones.
+func (c *Client) header(s HTTPSource, user *url.Userinfo) http.Header {
+	req := &http.Request{Header: http.Header{}}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	if user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+	}
+	for _, h := range []http.Header{c.Headers, s.Headers} {
+		for k, vs := range h {
+			req.Header[http.CanonicalHeaderKey(k)] = append([]string(nil), vs...)
+		}
+	}
+	return req.Header
+}
+
+// statusErr returns the failure of dest answering resp, with the body cut to
+// MaxBodySize.
+func (c *Client) statusErr(dest string, resp *http.Response, body []byte) error {
+	if max := c.maxBodySize(); int64(len(body)) > max {
+		body = body[:max]
+	}
+	return &HTTPStatusError{URL: dest, StatusCode: resp.StatusCode, Body: body}
+}
+
+// transient reports whether the failure of a request may not happen again, so
+// that it's worth retrying: a timeout, a refused, reset or dropped connection,
+// or a temporary failure of DNS. Other failures, such as an unknown host, an
+// unsupported scheme or an invalid certificate, would happen again.
+func transient(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryAfter returns the delay asked by the Retry-After header, either in
+// seconds or as an HTTP date, or zero. An amount of seconds too large for a
+// time.Duration is ignored.
+func retryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if secs < 0 || secs > int64(math.MaxInt64/time.Second) {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// maxRetryAfter is the longest pause a service gets with Retry-After:
+// TotalTimeout when set, or else BackoffMax, so that a service cannot hold a
+// lookup without a deadline for as long as it wants.
+func (c *Client) maxRetryAfter() time.Duration {
+	if c.TotalTimeout > 0 {
+		return c.TotalTimeout
+	}
+	if c.BackoffMax > 0 {
+		return c.BackoffMax
+	}
+	return 10 * time.Second
 }
 
 // GetIPStrBy queries an API to retrieve a `string` of this machine's public IP
@@ -96,43 +290,362 @@ func GetIPBy(dest string) (net.IP, error) {
 //				fmt.Println("My IP address is:", ip)
 //			}
 //		}
-func GetIPStrBy(dest string) (string, error) {
-	ip, err := GetIPBy(dest)
-	return ip.String(), err
+func GetIPStrBy(dest string, opts ...Option) (string, error) {
+	ip, err := GetIPBy(dest, opts...)
+	if err != nil {
+		return "", err
+	}
+	return ip.String(), nil
 }
 
-func detailErr(err error, errs []error) error {
-	errStrs := []string{err.Error()}
-	for _, e := range errs {
-		errStrs = append(errStrs, e.Error())
+// do sends req once and reads the response body, both bounded by timeout, or
+// by RequestTimeout when zero. At most one byte more than MaxBodySize is read, so that
+// longer bodies can be told apart without being read entirely.
+func (c *Client) do(client *http.Client, req *http.Request, timeout time.Duration) (*http.Response, []byte, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if d := c.requestTimeout(timeout); d > 0 {
+		ctx, cancel = context.WithTimeout(req.Context(), d)
+	} else {
+		ctx, cancel = context.WithCancel(req.Context())
+	}
+	defer cancel()
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	r, err := decode(resp)
+	if err != nil {
+		return nil, nil, err
 	}
-	j := strings.Join(errStrs, "\n")
-	return errors.New(j)
+	// The limit applies to the decompressed body, which may be much larger.
+	body, err := ioutil.ReadAll(io.LimitReader(r, c.maxBodySize()+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
 }
 
-func validate(rs []net.IP) (net.IP, error) {
-	if rs == nil {
-		return nil, fmt.Errorf("Failed to get any result from %d APIs", len(APIURIs))
+// decode returns the body of resp decompressed according to its
+// Content-Encoding, which the transport only does itself when it asked for
+// gzip. Both the zlib and the raw formats of deflate are accepted.
+func decode(resp *http.Response) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(resp.Body)
+	case "deflate":
+		br := bufio.NewReader(resp.Body)
+		if h, err := br.Peek(2); err == nil && h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
 	}
-	if len(rs) < 3 {
-		return nil, fmt.Errorf("Less than %d results from %d APIs", 3, len(APIURIs))
+	return resp.Body, nil
+}
+
+// sleep pauses for d or until ctx is done, whichever comes first.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	first := rs[0]
+}
+
+// Result is the outcome of a lookup. It's encoded in JSON with its Duration as
+// a string, such as "123ms", and its Timestamp in RFC 3339.
+type Result struct {
+	// IP is the public IP address of this machine. It never carries an IPv6
+	// zone, which the services may answer but is dropped.
+	IP net.IP `json:"ip"`
+	// Source is the URI of the service which answered IP. For a consensus
+	// among several services, it's the first one which answered.
+	Source string `json:"source"`
+	// Duration is the time Source took to answer, retries included.
+	Duration time.Duration `json:"duration"`
+	// Timestamp is when Source answered, which a cached result keeps.
+	Timestamp time.Time `json:"timestamp"`
+	// Retries is the amount of retries Source needed, zero when it answered
+	// at the first try.
+	Retries int `json:"retries"`
+	// Dissent maps the services which answered another address of the same
+	// family, ignored by the consensus, to their answer. It only covers the
+	// services which answered before the lookup ended, which Majority and
+	// Weighted may end early, unless it comes from GetWithDissent.
+	Dissent map[string]string `json:"dissent,omitempty"`
+}
+
+// MarshalJSON encodes r, with Duration as a string.
+func (r Result) MarshalJSON() ([]byte, error) {
+	type result Result
+	return json.Marshal(struct {
+		result
+		Duration string `json:"duration"`
+	}{result(r), r.Duration.String()})
+}
+
+// UnmarshalJSON decodes r, with Duration as a string.
+func (r *Result) UnmarshalJSON(data []byte) error {
+	type result Result
+	v := struct {
+		*result
+		Duration string `json:"duration"`
+	}{result: (*result)(r)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	r.Duration = 0
+	if v.Duration != "" {
+		d, err := time.ParseDuration(v.Duration)
+		if err != nil {
+			return fmt.Errorf("Invalid duration: %w", err)
+		}
+		r.Duration = d
+	}
+	return nil
+}
+
+// clone returns a copy of r which shares nothing with it.
+func (r Result) clone() Result {
+	r.IP = append(net.IP(nil), r.IP...)
+	if r.Dissent != nil {
+		d := make(map[string]string, len(r.Dissent))
+		for k, v := range r.Dissent {
+			d[k] = v
+		}
+		r.Dissent = d
+	}
+	return r
+}
+
+// validate requires at least the quorum of identical results. Only the
+// results of the same address family are compared with each other: when f is
+// anyFamily, it returns the address of the family which reached the quorum,
+// the one MixedFamily prefers if both did. Under StrictUnanimous, all the
+// results are compared with each other first.
+func (c *Client) validate(rs []Result, f Family) (Result, error) {
+	if c.Consensus == StrictUnanimous && !identical(rs) {
+		return Result{}, newDisagreementError("Results are not unanimous", rs)
+	}
+	if f == anyFamily && len(rs) > 0 {
+		if c.MixedFamily == RequireSameFamily && count(rs, IPv4) > 0 && count(rs, IPv6) > 0 {
+			return Result{}, newDisagreementError("Results are not of the same family", rs)
+		}
+		first, second := c.MixedFamily.families(rs)
+		r, err1 := c.validate(rs, first)
+		if err1 == nil {
+			return r, nil
+		}
+		r, err2 := c.validate(rs, second)
+		if err2 == nil {
+			return r, nil
+		}
+		if count(rs, second) > count(rs, first) {
+			return Result{}, err2
+		}
+		return Result{}, err1
+	}
+
+	var frs []Result
+	for _, r := range rs {
+		if f.match(r.IP) {
+			frs = append(frs, r)
+		}
+	}
+	rs = frs
+	if len(rs) == 0 {
+		if f != anyFamily {
+			return Result{}, insufficient("Failed to get any %s result from %d APIs", f, len(c.sources()))
+		}
+		return Result{}, insufficient("Failed to get any result from %d APIs", len(c.sources()))
+	}
+	if c.Consensus == Weighted {
+		return c.weighted(rs)
+	}
+	q := c.quorum()
+	if len(rs) < q {
+		reason := fmt.Sprintf("Less than %d results from %d APIs", q, len(c.sources()))
+		if !identical(rs) {
+			return Result{}, newDisagreementError(reason, rs)
+		}
+		return Result{}, insufficient("%s", reason)
+	}
+	if c.Consensus == Majority {
+		return majority(rs)
+	}
+	if !identical(rs) {
+		return Result{}, newDisagreementError("Results are not identical", rs)
+	}
+	return rs[0], nil
+}
+
+// identical reports whether all the results have the same address.
+func identical(rs []Result) bool {
 	for i := 1; i < len(rs); i++ {
-		if !reflect.DeepEqual(first, rs[i]) { //first != rs[i] {
-			return nil, fmt.Errorf("Results are not identical: %s", rs)
+		if !rs[0].IP.Equal(rs[i].IP) {
+			return false
+		}
+	}
+	return true
+}
+
+// count returns the amount of results of the family.
+func count(rs []Result, f Family) int {
+	n := 0
+	for _, r := range rs {
+		if f.match(r.IP) {
+			n++
 		}
 	}
-	return first, nil
+	return n
+}
+
+// lookup queries s with the settings of the client, and checks its answer. It
+// also returns the amount of retries of an HTTPSource, zero for the others.
+func (c *Client) lookup(ctx context.Context, s Source, f Family) (ip net.IP, retries int, err error) {
+	ctx, span := startSpan(ctx, "pubip.Source", attribute.String("pubip.source", s.String()))
+	defer func() { endSpan(span, err) }()
+
+	switch s := s.(type) {
+	case HTTPSource:
+		ip, retries, err = c.getIPBy(ctx, s, f)
+	case DNSSource:
+		ip, err = c.withRequestTimeout(ctx, s.Timeout, func(ctx context.Context) (net.IP, error) {
+			return s.lookup(ctx, f, c.dial())
+		})
+	case STUNSource:
+		ip, err = c.withRequestTimeout(ctx, s.Timeout, func(ctx context.Context) (net.IP, error) {
+			return s.lookup(ctx, f, c.dial())
+		})
+	default:
+		ip, err = c.withRequestTimeout(ctx, 0, s.Lookup)
+	}
+	if err != nil {
+		return nil, retries, err
+	}
+	if err := c.check(ip, f); err != nil {
+		return nil, retries, err
+	}
+	return ip, retries, nil
+}
+
+// parseIP is like net.ParseIP but also accepts an IPv6 address followed by a
+// zone, such as "fe80::1%eth0". The zone is dropped, since the address is
+// looked at from the Internet: the result is always zoneless.
+func parseIP(s string) net.IP {
+	if i := strings.IndexByte(s, '%'); i >= 0 && strings.Contains(s[:i], ":") {
+		s = s[:i]
+	}
+	return net.ParseIP(s)
+}
+
+// firstIP returns the first of the words of s separated by white spaces which
+// is an IP address, such as 203.0.113.5 in "203.0.113.5\n<!-- cached -->".
+func firstIP(s string) net.IP {
+	for _, w := range strings.Fields(s) {
+		if ip := parseIP(w); ip != nil {
+			return ip
+		}
+	}
+	return nil
+}
+
+// check rejects an address out of the family, or which is not public unless
+// AllowPrivate is set.
+func (c *Client) check(ip net.IP, f Family) error {
+	if !f.match(ip) {
+		return errors.New("IP address not " + f.String() + ": " + ip.String())
+	}
+	if !c.AllowPrivate && !isPublic(ip) {
+		return errors.New("IP address not public: " + ip.String())
+	}
+	return nil
+}
+
+// isPublic reports whether ip may be the public address of a host, that is
+// neither private, loopback, link-local, multicast nor unspecified.
+func isPublic(ip net.IP) bool {
+	return !ip.IsPrivate() &&
+		!ip.IsLoopback() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified()
+}
+
+// requestTimeout returns the timeout of a source, or RequestTimeout when zero.
+func (c *Client) requestTimeout(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return c.RequestTimeout
+}
+
+// withRequestTimeout calls lookup with ctx bounded by timeout, or by
+// RequestTimeout when zero.
+func (c *Client) withRequestTimeout(ctx context.Context, timeout time.Duration, lookup func(context.Context) (net.IP, error)) (net.IP, error) {
+	if d := c.requestTimeout(timeout); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	return lookup(ctx)
 }
 
-func worker(d string, r chan<- net.IP, e chan<- error) {
-	ip, err := GetIPBy(d)
+func (c *Client) worker(ctx context.Context, s Source, f Family, sem chan struct{}, r chan<- Result, e chan<- SourceError) {
+	if sem != nil {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+		case <-ctx.Done():
+			e <- SourceError{Source: s.String(), Err: ctx.Err()}
+			return
+		}
+	}
+
+	if !c.allow(s.String()) {
+		e <- SourceError{Source: s.String(), Err: ErrCircuitOpen}
+		return
+	}
+
+	start := time.Now()
+	ip, retries, err := c.lookup(ctx, s, f)
+	now := time.Now()
+	if ctx.Err() != nil {
+		c.release(s.String())
+	} else {
+		c.record(s.String(), err)
+		c.observeSource(s.String(), err, now.Sub(start))
+		c.observeRetries(s.String(), retries)
+	}
 	if err != nil {
-		e <- err
+		c.warnf("%s failed: %s", s, err)
+		e <- SourceError{Source: s.String(), Err: err, Duration: now.Sub(start), Retries: retries}
 		return
 	}
-	r <- ip
+	c.debugf("%s answered %s in %s", s, ip, now.Sub(start))
+	r <- Result{IP: ip, Source: s.String(), Duration: now.Sub(start), Timestamp: now, Retries: retries}
+}
+
+// start launches a worker per service of srcs, at most MaxConcurrency of them
+// querying at the same time. Both channels are buffered so that the workers
+// never block, even once nobody is waiting for them.
+func (c *Client) start(ctx context.Context, srcs []Source, f Family) (<-chan Result, <-chan SourceError) {
+	resultCh := make(chan Result, len(srcs))
+	errCh := make(chan SourceError, len(srcs))
+	var sem chan struct{}
+	if c.MaxConcurrency > 0 {
+		sem = make(chan struct{}, c.MaxConcurrency)
+	}
+	for _, s := range srcs {
+		go c.worker(ctx, s, f, sem, resultCh, errCh)
+	}
+	return resultCh, errCh
 }
 
 // Get queries several APIs to retrieve a `net.IP` of this machine's public IP
@@ -155,33 +668,155 @@ func worker(d string, r chan<- net.IP, e chan<- error) {
 //				fmt.Println("My IP address is:", ip)
 //			}
 //		}
-func Get() (net.IP, error) {
-	var results []net.IP
-	resultCh := make(chan net.IP, len(APIURIs))
-	var errs []error
-	errCh := make(chan error, len(APIURIs))
+func Get(opts ...Option) (net.IP, error) {
+	return NewClient(opts...).Get()
+}
+
+// GetIP is an alias of Get, named after GetIPBy. Both return the parsed
+// `net.IP` so callers can inspect it (e.g. `ip.To4() != nil`) without parsing
+// the string form again.
+func GetIP(opts ...Option) (net.IP, error) {
+	return Get(opts...)
+}
 
-	for _, d := range APIURIs {
-		go worker(d, resultCh, errCh)
+// GetContext is like Get but carries a context. If the context is done before
+// the results are collected, ctx.Err() is returned. Concurrent lookups with
+// the same settings share the same requests, which are canceled once the
+// lookup ends.
+func GetContext(ctx context.Context, opts ...Option) (net.IP, error) {
+	return NewClient(opts...).GetContext(ctx)
+}
+
+// get answers from the cache when CacheTTL is set, or looks up.
+func (c *Client) get(ctx context.Context, f Family) (Result, error) {
+	key := c.flightKey(f)
+	if c.CacheTTL > 0 {
+		if r, ok := cached(key, c.CacheTTL); ok {
+			return r, nil
+		}
+		if c.StaleWhileRevalidate > 0 {
+			if r, ok := cached(key, c.CacheTTL+c.StaleWhileRevalidate); ok {
+				c.revalidate(f, key)
+				return r, nil
+			}
+		}
+	}
+	return c.refresh(ctx, f, key)
+}
+
+// refresh looks up, and caches the result when CacheTTL is set.
+func (c *Client) refresh(ctx context.Context, f Family, key string) (Result, error) {
+	r, err := c.share(ctx, f, key)
+	if err == nil && c.CacheTTL > 0 {
+		cache(key, r, c.CacheTTL+c.StaleWhileRevalidate)
 	}
+	return r, err
+}
+
+// share shares the lookup with the concurrent ones of the same settings. Only
+// the lookup which starts is rate limited, the others joining it. The lookup
+// is canceled once all its callers stopped waiting for it.
+func (c *Client) share(ctx context.Context, f Family, key string) (Result, error) {
+	w := join(ctx, key)
+	defer w.leave(key)
+	ch := flights.DoChan(key, func() (interface{}, error) {
+		if err := c.limit(w.ctx, key); err != nil {
+			return Result{}, err
+		}
+		return c.consensus(w.ctx, f)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return Result{}, res.Err
+		}
+		return res.Val.(Result).clone(), nil
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	}
+}
+
+func (c *Client) consensus(ctx context.Context, f Family) (Result, error) {
+	ctx, span := startSpan(ctx, "pubip.Get", attribute.String("pubip.family", f.String()), attribute.Int("pubip.quorum", c.quorum()))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var results []Result
+	var errs []SourceError
+	srcs := c.sample()
+	pending := map[string]bool{}
+	for _, s := range srcs {
+		pending[s.String()] = true
+	}
+	start := time.Now()
+	resultCh, errCh := c.start(ctx, srcs, f)
+	timeout := time.After(c.Timeout)
 	for {
 		select {
 		case err := <-errCh:
 			errs = append(errs, err)
+			delete(pending, err.Source)
+			if len(pending) == 0 {
+				return c.conclude(span, start, results, errs, f)
+			}
 		case r := <-resultCh:
 			results = append(results, r)
-		case <-time.After(Timeout):
-			r, err := validate(results)
-			if err != nil {
-				return nil, detailErr(err, errs)
+			delete(pending, r.Source)
+			// The remaining services are canceled once they cannot change
+			// the outcome anymore.
+			if len(pending) == 0 || c.settled(results, pending, f) {
+				return c.conclude(span, start, results, errs, f)
 			}
-			return r, nil
+		case <-ctx.Done():
+			endSpan(span, ctx.Err())
+			return Result{}, ctx.Err()
+		case <-timeout:
+			return c.conclude(span, start, results, errs, f)
 		}
 	}
 }
 
+// conclude decides the outcome of the lookup started at start, and reports it.
+func (c *Client) conclude(span trace.Span, start time.Time, results []Result, errs []SourceError, f Family) (Result, error) {
+	r, err := c.decide(results, errs, f)
+	span.SetAttributes(attribute.Int("pubip.results", len(results)), attribute.Bool("pubip.quorum_reached", err == nil))
+	if err != nil {
+		c.warnf("No consensus among %d results: %s", len(results), err)
+		err = &AggregateError{err, errs, results}
+		c.observeLookup(err, time.Since(start))
+		endSpan(span, err)
+		return Result{}, err
+	}
+	c.observeLookup(nil, time.Since(start))
+	endSpan(span, nil)
+	c.debugf("Consensus on %s among %d results", r.IP, len(results))
+	r.Dissent = dissent(results, r.IP)
+	return r, nil
+}
+
+// GetWithResults is like GetStr but waits for every service to answer, or for
+// Timeout, even once they agreed on an address. It also returns the outcome of
+// each of them, in the order they answered or failed.
+func GetWithResults(opts ...Option) ([]SourceResult, string, error) {
+	return NewClient(opts...).GetWithResults()
+}
+
+// GetDetailed is like Get but also tells which service answered and how long
+// it took.
+func GetDetailed(opts ...Option) (Result, error) {
+	return NewClient(opts...).GetDetailed()
+}
+
+// GetWithDissent is like GetStr but also returns the services which answered
+// another address of the same family, ignored by the consensus, and their
+// answer. It waits for every service to answer, or for Timeout.
+func GetWithDissent(opts ...Option) (ip string, dissent map[string]string, err error) {
+	return NewClient(opts...).GetWithDissent()
+}
+
 // GetStr queries several APIs to retrieve a `string` of this machine's public
-// IP address.
+// IP address. An IPv6 address is in the canonical form of RFC 5952, lowercase
+// and compressed, whatever the form the services answered.
 //
 // Usage:
 //
@@ -200,7 +835,110 @@ func Get() (net.IP, error) {
 //				fmt.Println("My IP address is:", ip)
 //			}
 //		}
-func GetStr() (string, error) {
-	ip, err := Get()
-	return ip.String(), err
+func GetStr(opts ...Option) (string, error) {
+	ip, err := Get(opts...)
+	if err != nil {
+		return "", err
+	}
+	return ip.String(), nil
+}
+
+// GetAll queries several APIs and reports the answer of each of them, without
+// any validation. Every service of APIURIs is either in the returned addresses
+// or in the returned errors, including the ones which didn't answer within
+// Timeout.
+func GetAll(opts ...Option) (map[string]net.IP, map[string]error) {
+	return NewClient(opts...).GetAll()
+}
+
+// HealthCheck queries every service once, without any retry, and reports the
+// failure of each of them, nil for the ones answering a valid IP address. The
+// services which didn't answer before ctx is done or Timeout fail with the
+// error of the context.
+func HealthCheck(ctx context.Context, opts ...Option) map[string]error {
+	return NewClient(opts...).HealthCheck(ctx)
+}
+
+func (c *Client) getAll(ctx context.Context, f Family) (map[string]net.IP, map[string]error) {
+	rs, ses := c.collect(ctx, f)
+	results := map[string]net.IP{}
+	errs := map[string]error{}
+	for _, r := range rs {
+		if err := c.inRange(r.IP); err != nil {
+			errs[r.Source] = err
+			continue
+		}
+		results[r.Source] = r.IP
+	}
+	for _, se := range ses {
+		errs[se.Source] = se.Err
+	}
+	return results, errs
+}
+
+// collect queries every service and waits for all of them to answer, or fail.
+// The services which didn't before ctx is done or Timeout fail with the error
+// of the context.
+func (c *Client) collect(ctx context.Context, f Family) ([]Result, []SourceError) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	srcs := c.sources()
+	resultCh, errCh := c.start(ctx, srcs, f)
+	var results []Result
+	var errs []SourceError
+	done := map[string]bool{}
+	for n := 0; n < len(srcs); n++ {
+		select {
+		case r := <-resultCh:
+			results = append(results, r)
+			done[r.Source] = true
+		case e := <-errCh:
+			errs = append(errs, e)
+			done[e.Source] = true
+		case <-ctx.Done():
+			for _, s := range srcs {
+				if !done[s.String()] {
+					errs = append(errs, SourceError{Source: s.String(), Err: ctx.Err()})
+					done[s.String()] = true
+				}
+			}
+			return results, errs
+		}
+	}
+	return results, errs
+}
+
+// GetFirst queries several APIs and returns the first valid answer, without
+// waiting for a consensus. The requests still in flight are canceled once it
+// returns.
+func GetFirst(opts ...Option) (net.IP, error) {
+	return NewClient(opts...).GetFirst()
+}
+
+func (c *Client) getFirst(ctx context.Context, f Family) (Result, error) {
+	if c.networksErr != nil {
+		return Result{}, c.networksErr
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	srcs := c.sources()
+	resultCh, errCh := c.start(ctx, srcs, f)
+	n := len(srcs)
+	var errs []SourceError
+	for i := 0; i < n; i++ {
+		select {
+		case r := <-resultCh:
+			if err := c.inRange(r.IP); err != nil {
+				return Result{}, err
+			}
+			return r, nil
+		case e := <-errCh:
+			errs = append(errs, e)
+		case <-ctx.Done():
+			return Result{}, &AggregateError{ctx.Err(), errs, nil}
+		}
+	}
+	return Result{}, &AggregateError{insufficient("Failed to get any result from %d APIs", n), errs, nil}
 }
@@ -1,9 +1,29 @@
 package pubip
 
 import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestIsValidate(t *testing.T) {
@@ -18,13 +38,772 @@ func TestIsValidate(t *testing.T) {
 		{[]net.IP{net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.1")}, net.ParseIP("192.168.1.1")},
 		{[]net.IP{net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.2")}, nil},
 		{[]net.IP{net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.2")}, nil},
+		{[]net.IP{net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.1").To4(), net.ParseIP("192.168.1.1")}, net.ParseIP("192.168.1.1")},
+		{[]net.IP{net.ParseIP("192.168.1.1"), net.ParseIP("2001:db8::1"), net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.1")}, net.ParseIP("192.168.1.1")},
+		{[]net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("192.168.1.1"), net.ParseIP("2001:DB8:0::1"), net.ParseIP("2001:db8::1")}, net.ParseIP("2001:db8::1")},
+		{[]net.IP{net.ParseIP("192.168.1.1"), net.ParseIP("2001:db8::1"), net.ParseIP("192.168.1.1")}, nil},
 	}
 	for i, v := range tests {
-		actual, _ := validate(v.input)
+		r, _ := NewClient().validate(toResults(v.input), anyFamily)
+		actual := r.IP
 		expected := v.expected
 		t.Logf("Check case %d: %s(actual) == %s(expected)", i, actual, expected)
-		if !reflect.DeepEqual(actual, expected) {
+		if !actual.Equal(expected) {
 			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, actual, expected)
 		}
 	}
 }
+
+// toResults wraps addresses as if each was answered by a service.
+func toResults(ips []net.IP) []Result {
+	var rs []Result
+	for i, ip := range ips {
+		rs = append(rs, Result{IP: ip, Source: "http://api" + strconv.Itoa(i)})
+	}
+	return rs
+}
+
+func TestGetIPStrByError(t *testing.T) {
+	ip, err := GetIPStrBy("://invalid")
+	if err == nil {
+		t.Fatal("Expected an error for an invalid URL")
+	}
+	if ip != "" {
+		t.Errorf("Expected an empty string on error, got %q", ip)
+	}
+}
+
+func TestValidateQuorum(t *testing.T) {
+	defer func(q int, uris []string) {
+		Quorum, APIURIs = q, uris
+	}(Quorum, APIURIs)
+
+	ip := net.ParseIP("192.168.1.1")
+	tests := []struct {
+		quorum   int
+		uris     []string
+		input    []net.IP
+		expected net.IP
+	}{
+		{1, APIURIs, []net.IP{ip}, ip},
+		{2, APIURIs, []net.IP{ip}, nil},
+		{3, []string{"http://a", "http://b"}, []net.IP{ip, ip}, ip},
+		{3, []string{"http://a", "http://b"}, []net.IP{ip}, nil},
+	}
+	for i, v := range tests {
+		Quorum, APIURIs = v.quorum, v.uris
+		r, _ := NewClient().validate(toResults(v.input), anyFamily)
+		actual := r.IP
+		if !reflect.DeepEqual(actual, v.expected) {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, actual, v.expected)
+		}
+	}
+}
+
+func TestGetCancelsWorkers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	defer func(uris []string, d time.Duration) {
+		APIURIs, Timeout = uris, d
+	}(APIURIs, Timeout)
+	APIURIs = []string{srv.URL, srv.URL, srv.URL}
+	Timeout = 100 * time.Millisecond
+
+	before := runtime.NumGoroutine()
+	if _, err := Get(); err == nil {
+		t.Fatal("Expected an error from hanging services")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Goroutines leaked: %d(after) > %d(before)", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGetEndsOnceAllAnswered(t *testing.T) {
+	a := newIPServer("203.0.113.1")
+	defer a.Close()
+	b := newIPServer("203.0.113.2")
+	defer b.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	defer down.Close()
+
+	tests := []struct {
+		uris []string
+		err  string
+	}{
+		{[]string{a.URL, a.URL + "/other", down.URL}, ""},
+		{[]string{a.URL, b.URL, down.URL}, "Results are not identical: 203.0.113.1 (1 source), 203.0.113.2 (1 source)"},
+	}
+	for i, v := range tests {
+		start := time.Now()
+		_, err := Get(WithSources(v.uris...), WithQuorum(2), WithTimeout(5*time.Second))
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("Error on case %d: took %s despite all the services having answered", i, d)
+		}
+		var aggErr *AggregateError
+		switch {
+		case v.err == "" && err != nil:
+			t.Errorf("Error on case %d: unexpected error %s", i, err)
+		case v.err != "" && (!errors.As(err, &aggErr) || aggErr.Err.Error() != v.err):
+			t.Errorf("Error on case %d: %v(actual) != %s(expected)", i, err, v.err)
+		}
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestHTTPClient(t *testing.T) {
+	defer func(c *http.Client) {
+		HTTPClient = c
+	}(HTTPClient)
+	HTTPClient = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader("203.0.113.1")),
+			Request:    r,
+		}, nil
+	})}
+
+	ip, err := GetIPBy("http://example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := net.ParseIP("203.0.113.1"); !ip.Equal(expected) {
+		t.Errorf("%s(actual) != %s(expected)", ip, expected)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	tests := []struct {
+		opts     []Option
+		expected string
+	}{
+		{nil, "go-pubip/" + Version},
+		{[]Option{WithUserAgent("custom/1.0")}, "custom/1.0"},
+	}
+	for i, v := range tests {
+		var actual string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actual = r.Header.Get("User-Agent")
+			w.Write([]byte("203.0.113.1"))
+		}))
+		if _, err := GetIPBy(srv.URL, v.opts...); err != nil {
+			t.Errorf("Error on case %d: %s", i, err)
+		}
+		srv.Close()
+		if actual != v.expected {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, actual, v.expected)
+		}
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	var actual http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0k3n" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		actual = r.Header
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer srv.Close()
+
+	l := &testLogger{}
+	_, err := Get(
+		WithSources(),
+		WithExtraSources(HTTPSource{URL: srv.URL, Headers: http.Header{"X-Source": {"source"}, "X-Both": {"source"}}}),
+		WithHeader("Authorization", "Bearer t0k3n"),
+		WithHeader("X-Both", "client"),
+		WithHeader("User-Agent", "custom/1.0"),
+		WithTimeout(100*time.Millisecond),
+		WithLogger(l),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for k, expected := range map[string]string{
+		"User-Agent": "custom/1.0",
+		"X-Source":   "source",
+		"X-Both":     "source",
+	} {
+		if v := actual.Get(k); v != expected {
+			t.Errorf("Error on %s: %s(actual) != %s(expected)", k, v, expected)
+		}
+	}
+	for _, line := range l.lines {
+		if strings.Contains(line, "t0k3n") {
+			t.Errorf("Credentials logged: %s", line)
+		}
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+		w.Write([]byte("203.0.113.2"))
+	}))
+	defer slow.Close()
+	fast := newIPServer("203.0.113.1")
+	defer fast.Close()
+
+	opts := []Option{WithRequestTimeout(50 * time.Millisecond), WithMaxTries(1)}
+	if _, err := GetIPBy(slow.URL, opts...); err == nil {
+		t.Error("Expected the slow service to fail")
+	}
+
+	opts = append(opts, WithSources(slow.URL, fast.URL, fast.URL+"/other"), WithQuorum(2), WithTimeout(500*time.Millisecond))
+	ip, err := Get(opts...)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := net.ParseIP("203.0.113.1"); !ip.Equal(expected) {
+		t.Errorf("%s(actual) != %s(expected)", ip, expected)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		{"99999999999999999", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	}
+	for i, v := range tests {
+		h := http.Header{}
+		h.Set("Retry-After", v.input)
+		if actual := retryAfter(h); actual != v.expected {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, actual, v.expected)
+		}
+	}
+
+	h := http.Header{}
+	h.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if actual := retryAfter(h); actual < 59*time.Minute || actual > time.Hour {
+		t.Errorf("Expected about an hour from an HTTP date, got %s", actual)
+	}
+}
+
+func TestRetryAfterIsCapped(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Header().Set("Retry-After", "999999999")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	if _, err := GetIPBy(srv.URL, WithBackoff(time.Millisecond, 50*time.Millisecond, 2)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Expected the pause to be cut to BackoffMax, took %s", d)
+	}
+}
+
+func TestRetryOnTooManyRequests(t *testing.T) {
+	var mu sync.Mutex
+	tries := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		tries[r.URL.Path]++
+		n := tries[r.URL.Path]
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/404":
+			w.WriteHeader(http.StatusNotFound)
+		case n == 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte("203.0.113.1"))
+		}
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	if _, err := GetIPBy(srv.URL + "/429"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("Retried after %s despite a Retry-After of 1 second", d)
+	}
+
+	if _, err := GetIPBy(srv.URL + "/404"); err == nil {
+		t.Error("Expected an error on 404")
+	}
+	if tries["/404"] != 1 {
+		t.Errorf("Expected a single try on 404, got %d", tries["/404"])
+	}
+}
+
+func TestDisableBackoff(t *testing.T) {
+	var tries int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tries, 1)
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	if _, err := GetIPBy(srv.URL, WithDisableBackoff(true)); err == nil {
+		t.Fatal("Expected an error on 503")
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("Took %s to fail with the backoff disabled", d)
+	}
+	if n := atomic.LoadInt32(&tries); n != MaxTries {
+		t.Errorf("Expected %d tries, got %d", MaxTries, n)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		opt      Option
+		min, max time.Duration
+	}{
+		{WithBackoff(10*time.Millisecond, 10*time.Millisecond, 1), 20 * time.Millisecond, 100 * time.Millisecond},
+		{WithBackoff(100*time.Millisecond, time.Second, 3), 200 * time.Millisecond, 2 * time.Second},
+		// Without jitter, the pauses are 100ms then 300ms.
+		{WithBackoffJitter(false), 400 * time.Millisecond, 500 * time.Millisecond},
+	}
+	for i, v := range tests {
+		start := time.Now()
+		if _, err := GetIPBy(srv.URL, WithBackoff(100*time.Millisecond, time.Second, 3), v.opt); err == nil {
+			t.Errorf("Error on case %d: expected an error on 503", i)
+		}
+		if d := time.Since(start); d < v.min || d > v.max {
+			t.Errorf("Error on case %d: took %s, not between %s and %s", i, d, v.min, v.max)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		failures int32
+		expected int
+	}{
+		{0, 0},
+		{1, 1},
+		{2, 2},
+	}
+	for i, v := range tests {
+		var hits int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&hits, 1) <= v.failures {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("203.0.113.1"))
+		}))
+		r, err := GetIPByDetailed(srv.URL, WithBackoff(time.Millisecond, time.Millisecond, 1))
+		srv.Close()
+		if err != nil {
+			t.Errorf("Error on case %d: unexpected error %s", i, err)
+			continue
+		}
+		if r.Retries != v.expected || r.Source != srv.URL || r.IP.String() != "203.0.113.1" {
+			t.Errorf("Error on case %d: %d(actual) != %d(expected)", i, r.Retries, v.expected)
+		}
+	}
+}
+
+func TestCancelDuringBackoff(t *testing.T) {
+	var tries int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tries, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for atomic.LoadInt32(&tries) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	start := time.Now()
+	_, err := NewClient(WithBackoff(10*time.Second, 10*time.Second, 1)).GetIPByContext(ctx, srv.URL)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("%v(actual) != %v(expected)", err, context.Canceled)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Took %s to return after the cancellation", d)
+	}
+	if n := atomic.LoadInt32(&tries); n != 1 {
+		t.Errorf("%d(actual) != 1(expected) tries", n)
+	}
+}
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{&url.Error{Op: "Get", Err: context.DeadlineExceeded}, true},
+		{&net.DNSError{Err: "server misbehaving", IsTemporary: true}, true},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{&url.Error{Op: "Get", Err: x509.UnknownAuthorityError{}}, false},
+		{errors.New(`unsupported protocol scheme "ftp"`), false},
+	}
+	for i, v := range tests {
+		if actual := transient(v.err); actual != v.expected {
+			t.Errorf("Error on case %d: %t(actual) != %t(expected)", i, actual, v.expected)
+		}
+	}
+}
+
+func TestRetriedStatusCodes(t *testing.T) {
+	tests := []struct {
+		status   int
+		expected int32
+	}{
+		{http.StatusBadGateway, 3},
+		{http.StatusTooManyRequests, 3},
+		{http.StatusNotFound, 1},
+		{http.StatusForbidden, 1},
+	}
+	for i, v := range tests {
+		var tries int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&tries, 1)
+			w.WriteHeader(v.status)
+		}))
+		if _, err := GetIPBy(srv.URL, WithDisableBackoff(true)); err == nil {
+			t.Errorf("Error on case %d: expected an error on %d", i, v.status)
+		}
+		srv.Close()
+		if tries != v.expected {
+			t.Errorf("Error on case %d: %d(actual) != %d(expected)", i, tries, v.expected)
+		}
+	}
+}
+
+func TestCanonicalIPv6(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"2001:0db8::0001", "2001:db8::1"},
+		{"2001:DB8::AB", "2001:db8::ab"},
+		{"2001:db8:0:0:0:0:0:1", "2001:db8::1"},
+		// Only the longest run of zeros is compressed, the first one on a tie.
+		{"2001:0:0:1:0:0:0:1", "2001:0:0:1::1"},
+		{"2001:db8:0:0:1:0:0:1", "2001:db8::1:0:0:1"},
+		// A single zero field is never compressed.
+		{"2001:db8:0:1:1:1:1:1", "2001:db8:0:1:1:1:1:1"},
+		{"2001:db8::1:0:0:0", "2001:db8:0:0:1::"},
+		// An IPv4-mapped address is answered as IPv4.
+		{"::ffff:203.0.113.1", "203.0.113.1"},
+	}
+	for i, v := range tests {
+		srv := newIPServer(v.input)
+		actual, err := GetStr(WithSources(srv.URL), WithQuorum(1))
+		srv.Close()
+		if err != nil || actual != v.expected {
+			t.Errorf("Error on case %d: %s, %v(actual) != %s(expected)", i, actual, err, v.expected)
+		}
+	}
+}
+
+func TestPerCallTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		timeout time.Duration
+		fails   bool
+	}{
+		{50 * time.Millisecond, true},
+		{time.Second, false},
+		{50 * time.Millisecond, true},
+		{time.Second, false},
+	}
+	errs := make([]error, len(tests))
+	elapsed := make([]time.Duration, len(tests))
+	var wg sync.WaitGroup
+	for i, v := range tests {
+		wg.Add(1)
+		go func(i int, timeout time.Duration) {
+			defer wg.Done()
+			start := time.Now()
+			_, errs[i] = Get(WithSources(srv.URL), WithQuorum(1), WithTimeout(timeout))
+			elapsed[i] = time.Since(start)
+		}(i, v.timeout)
+	}
+	wg.Wait()
+	for i, v := range tests {
+		if (errs[i] != nil) != v.fails {
+			t.Errorf("Error on case %d: unexpected error %v", i, errs[i])
+		}
+		if v.fails && elapsed[i] > 150*time.Millisecond {
+			t.Errorf("Error on case %d: expected to give up after %s, took %s", i, v.timeout, elapsed[i])
+		}
+	}
+	if Timeout != 2*time.Second {
+		t.Errorf("%s(actual) != %s(expected)", Timeout, 2*time.Second)
+	}
+}
+
+func TestTotalTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	_, err := GetIPBy(srv.URL,
+		WithMaxTries(100),
+		WithBackoff(100*time.Millisecond, 100*time.Millisecond, 1),
+		WithTotalTimeout(250*time.Millisecond),
+	)
+	if d := time.Since(start); d < 250*time.Millisecond || d > time.Second {
+		t.Errorf("Took %s, expected about 250ms", d)
+	}
+	if err == nil || !strings.Contains(err.Error(), "status code 503") {
+		t.Errorf("Expected the failure of the last try, got %v", err)
+	}
+
+	// The context of the caller still wins.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = NewClient(WithTotalTimeout(time.Second)).GetIPByContext(ctx, srv.URL)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("%v(actual) != %v(expected)", err, context.DeadlineExceeded)
+	}
+}
+
+func TestCompressedBody(t *testing.T) {
+	tests := []struct {
+		encoding string
+		compress func(w io.Writer) io.WriteCloser
+	}{
+		{"gzip", func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+		{"deflate", func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }},
+		{"deflate", func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		}},
+	}
+	for i, v := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", v.encoding)
+			cw := v.compress(w)
+			cw.Write([]byte("203.0.113.1\n"))
+			cw.Close()
+		}))
+		// Asking for an encoding stops the transport from decompressing gzip.
+		ip, err := GetIPBy(srv.URL, WithHeader("Accept-Encoding", v.encoding))
+		srv.Close()
+		if err != nil {
+			t.Errorf("Error on case %d: %s", i, err)
+			continue
+		}
+		if expected := net.ParseIP("203.0.113.1"); !ip.Equal(expected) {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, ip, expected)
+		}
+	}
+}
+
+func TestIPv6Zone(t *testing.T) {
+	tests := []struct {
+		input        string
+		allowPrivate bool
+		expected     string
+		err          string
+	}{
+		{"2001:db8::1%eth0", false, "2001:db8::1", ""},
+		// A link-local address is never public, zone or not.
+		{"fe80::1%eth0", false, "", "IP address not public: fe80::1"},
+		{"fe80::1%eth0", true, "fe80::1", ""},
+		{"203.0.113.1%eth0", false, "", "IP address not valid: 203.0.113.1%eth0"},
+	}
+	for i, v := range tests {
+		srv := newIPServer(v.input)
+		ip, err := GetIPBy(srv.URL, WithAllowPrivate(v.allowPrivate))
+		srv.Close()
+		if err != nil {
+			if err.Error() != v.err {
+				t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, err, v.err)
+			}
+			continue
+		}
+		if ip.String() != v.expected {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, ip, v.expected)
+		}
+	}
+}
+
+func TestLenientParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		lenient  bool
+		expected string
+		err      string
+	}{
+		{"203.0.113.5\n", false, "203.0.113.5", ""},
+		{"203.0.113.5\n", true, "203.0.113.5", ""},
+		{"203.0.113.5\n<!-- cached -->", false, "", `IP address not valid: "203.0.113.5\n<!-- cached -->"`},
+		{"203.0.113.5\n<!-- cached -->", true, "203.0.113.5", ""},
+		{"Your IP: 2001:db8::1 (IPv6)", true, "2001:db8::1", ""},
+		{"<html>Bad Gateway</html>", true, "", "IP address not valid: <html>Bad Gateway</html>"},
+	}
+	for i, v := range tests {
+		srv := newIPServer(v.input)
+		ip, err := GetIPBy(srv.URL, WithLenientParsing(v.lenient))
+		srv.Close()
+		if err != nil {
+			if err.Error() != v.err {
+				t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, err, v.err)
+			}
+			continue
+		}
+		if ip.String() != v.expected {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, ip, v.expected)
+		}
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunk := []byte(strings.Repeat("0", 1024))
+		for {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := GetIPBy(srv.URL, WithMaxTries(1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "longer than 512 bytes") {
+			t.Errorf("Expected the body to be rejected, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reading an endless body didn't stop")
+	}
+}
+
+func TestHTTPClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("203."))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	hc := &http.Client{Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	_, err := GetIPBy(srv.URL, WithHTTPClient(hc), WithRequestTimeout(0), WithMaxTries(1))
+	if err == nil {
+		t.Fatal("Expected a stalled body to time out")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Timed out after %s instead of %s", d, hc.Timeout)
+	}
+}
+
+func TestRejectNonPublic(t *testing.T) {
+	tests := []struct {
+		input    string
+		opts     []Option
+		expected bool
+	}{
+		{"203.0.113.1", nil, true},
+		{"10.0.0.1", nil, false},
+		{"127.0.0.1", nil, false},
+		{"169.254.1.1", nil, false},
+		{"0.0.0.0", nil, false},
+		{"fe80::1", nil, false},
+		{"fd00::1", nil, false},
+		{"10.0.0.1", []Option{WithAllowPrivate(true)}, true},
+		{"127.0.0.1", []Option{WithAllowPrivate(true)}, true},
+	}
+	for i, v := range tests {
+		srv := newIPServer(v.input)
+		_, err := GetIPBy(srv.URL, v.opts...)
+		srv.Close()
+		if actual := err == nil; actual != v.expected {
+			t.Errorf("Error on case %d: %s accepted: %t(actual) != %t(expected), error: %v", i, v.input, actual, v.expected, err)
+		}
+	}
+}
+
+func TestResultJSON(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		r        Result
+		expected string
+	}{
+		{
+			Result{IP: net.ParseIP("203.0.113.1"), Source: "https://api.ipify.org", Duration: 123 * time.Millisecond, Timestamp: ts, Retries: 1},
+			`{"ip":"203.0.113.1","source":"https://api.ipify.org","timestamp":"2024-05-01T12:30:00Z","retries":1,"duration":"123ms"}`,
+		},
+		{
+			Result{IP: net.ParseIP("2001:db8::1"), Source: "http://ident.me", Duration: 1500 * time.Millisecond, Timestamp: ts, Dissent: map[string]string{"http://icanhazip.com": "2001:db8::2"}},
+			`{"ip":"2001:db8::1","source":"http://ident.me","timestamp":"2024-05-01T12:30:00Z","retries":0,"dissent":{"http://icanhazip.com":"2001:db8::2"},"duration":"1.5s"}`,
+		},
+	}
+	for i, v := range tests {
+		b, err := json.Marshal(v.r)
+		if err != nil {
+			t.Errorf("Error on case %d: unexpected error %s", i, err)
+			continue
+		}
+		if string(b) != v.expected {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, b, v.expected)
+		}
+		var r Result
+		if err := json.Unmarshal(b, &r); err != nil {
+			t.Errorf("Error on case %d: unexpected error %s", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(r.clone(), v.r.clone()) {
+			t.Errorf("Error on case %d: %+v(actual) != %+v(expected)", i, r, v.r)
+		}
+	}
+	var r Result
+	if err := json.Unmarshal([]byte(`{"duration":"soon"}`), &r); err == nil {
+		t.Error("Expected an invalid duration to fail")
+	}
+}
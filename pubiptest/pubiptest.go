@@ -0,0 +1,100 @@
+// Package pubiptest provides services answering chosen addresses, to test
+// the code using pubip without reaching the Internet.
+//
+// Usage:
+//
+//		srvs := pubiptest.NewServers(3, "203.0.113.1")
+//		defer srvs.Close()
+//		srvs[2].Answer("198.51.100.1")
+//		ip, err := srvs.Client(pubip.WithConsensus(pubip.Majority)).Get()
+package pubiptest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/chyeh/pubip"
+)
+
+// Server is an HTTP service answering an address as plain text, or failing
+// with a status code.
+type Server struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	answer string
+	status int
+}
+
+// NewServer starts a Server answering ip.
+func NewServer(ip string) *Server {
+	s := &Server{answer: ip, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	answer, status := s.answer, s.status
+	s.mu.Unlock()
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Write([]byte(answer))
+}
+
+// Answer makes the Server answer ip from now on, which doesn't need to be a
+// valid address.
+func (s *Server) Answer(ip string) {
+	s.mu.Lock()
+	s.answer, s.status = ip, http.StatusOK
+	s.mu.Unlock()
+}
+
+// Fail makes the Server fail with the status code from now on, such as
+// http.StatusServiceUnavailable, until Answer is called.
+func (s *Server) Fail(status int) {
+	s.mu.Lock()
+	s.status = status
+	s.mu.Unlock()
+}
+
+// Servers is a set of services.
+type Servers []*Server
+
+// NewServers starts n Servers answering ip.
+func NewServers(n int, ip string) Servers {
+	srvs := make(Servers, n)
+	for i := range srvs {
+		srvs[i] = NewServer(ip)
+	}
+	return srvs
+}
+
+// URLs returns the URIs of the Servers.
+func (srvs Servers) URLs() []string {
+	uris := make([]string, len(srvs))
+	for i, s := range srvs {
+		uris[i] = s.URL
+	}
+	return uris
+}
+
+// Client returns a pubip.Client querying the Servers only, with a Quorum of
+// all of them, then configured by opts.
+func (srvs Servers) Client(opts ...pubip.Option) *pubip.Client {
+	return pubip.NewClient(append([]pubip.Option{
+		pubip.WithSources(srvs.URLs()...),
+		pubip.WithExtraSources(),
+		pubip.WithQuorum(len(srvs)),
+	}, opts...)...)
+}
+
+// Close shuts the Servers down.
+func (srvs Servers) Close() {
+	for _, s := range srvs {
+		s.Close()
+	}
+}
@@ -0,0 +1,40 @@
+package pubiptest
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/chyeh/pubip"
+)
+
+func TestServers(t *testing.T) {
+	srvs := NewServers(3, "203.0.113.1")
+	defer srvs.Close()
+
+	tests := []struct {
+		setup     func()
+		consensus pubip.ConsensusStrategy
+		expected  string
+	}{
+		{func() {}, pubip.Unanimous, "203.0.113.1"},
+		{func() { srvs[2].Answer("198.51.100.1") }, pubip.Unanimous, ""},
+		{func() {}, pubip.Majority, "203.0.113.1"},
+		{func() { srvs[1].Fail(http.StatusServiceUnavailable) }, pubip.Majority, ""},
+		{func() { srvs[1].Answer("203.0.113.1"); srvs[2].Answer("203.0.113.1") }, pubip.Unanimous, "203.0.113.1"},
+	}
+	for i, v := range tests {
+		v.setup()
+		ip, err := srvs.Client(pubip.WithConsensus(v.consensus), pubip.WithDisableBackoff(true)).Get()
+		if v.expected == "" {
+			var aggErr *pubip.AggregateError
+			if !errors.As(err, &aggErr) {
+				t.Errorf("Error on case %d: expected an *AggregateError, got %T: %v", i, err, err)
+			}
+			continue
+		}
+		if err != nil || ip.String() != v.expected {
+			t.Errorf("Error on case %d: %s, %v(actual) != %s(expected)", i, ip, err, v.expected)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package pubip
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is the failure of a lookup started sooner than MinInterval
+// after the previous one with the same settings.
+var ErrRateLimited = errors.New("Rate limited")
+
+// lookups tracks when the lookups started by the key of their settings, so
+// that it's shared by the package level functions and the clients.
+var lookups = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: map[string]time.Time{}}
+
+// limit lets the lookup of the settings of key start, at least MinInterval
+// after the previous one. A lookup arriving sooner waits for its turn when
+// WaitRateLimit is set, or fails with ErrRateLimited.
+func (c *Client) limit(ctx context.Context, key string) error {
+	if c.MinInterval <= 0 {
+		return nil
+	}
+	lookups.Lock()
+	now := time.Now()
+	next := lookups.m[key].Add(c.MinInterval)
+	if !now.Before(next) {
+		lookups.m[key] = now
+		lookups.Unlock()
+		return nil
+	}
+	if !c.WaitRateLimit {
+		lookups.Unlock()
+		return ErrRateLimited
+	}
+	lookups.m[key] = next
+	lookups.Unlock()
+	return sleep(ctx, next.Sub(now))
+}
@@ -0,0 +1,65 @@
+package pubip
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		wait bool
+		err  error
+	}{
+		{false, ErrRateLimited},
+		{true, nil},
+	}
+	for i, v := range tests {
+		srv := newIPServer("203.0.113.1")
+		opts := []Option{WithSources(srv.URL), WithTimeout(50 * time.Millisecond), WithRateLimit(300*time.Millisecond, v.wait)}
+		if _, err := Get(opts...); err != nil {
+			t.Errorf("Error on case %d: unexpected error %s", i, err)
+		}
+		start := time.Now()
+		_, err := Get(opts...)
+		if !errors.Is(err, v.err) {
+			t.Errorf("Error on case %d: %v(actual) != %v(expected)", i, err, v.err)
+		}
+		if d := time.Since(start); v.wait && d < 200*time.Millisecond {
+			t.Errorf("Error on case %d: took %s, expected to wait for the interval", i, d)
+		}
+		srv.Close()
+	}
+}
+
+func TestRateLimitShared(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The lookup lasts until the others join it.
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer srv.Close()
+
+	opts := []Option{WithSources(srv.URL), WithTimeout(time.Second), WithRateLimit(time.Hour, false)}
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = Get(opts...)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Error on case %d: unexpected error %s", i, err)
+		}
+	}
+	if _, err := Get(opts...); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("%v(actual) != %v(expected)", err, ErrRateLimited)
+	}
+}
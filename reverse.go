@@ -0,0 +1,34 @@
+package pubip
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// reverseResolver resolves the PTR records for LookupReverse.
+var reverseResolver = net.DefaultResolver
+
+// LookupReverse returns the names the PTR records of ip point to, such as the
+// name an ISP gives to the public IP address, with the resolvers of the
+// system. It fails when ctx is done first.
+//
+// Usage:
+//
+//		ip, err := pubip.GetStr()
+//		if err != nil {
+//			return err
+//		}
+//		names, err := pubip.LookupReverse(ctx, ip)
+func LookupReverse(ctx context.Context, ip string) ([]string, error) {
+	if net.ParseIP(ip) == nil {
+		return nil, errors.New("IP address not valid: " + ip)
+	}
+	return reverseResolver.LookupAddr(ctx, ip)
+}
+
+// LookupReverse returns the names the PTR records of the address of r point
+// to, like the package level LookupReverse.
+func (r Result) LookupReverse(ctx context.Context) ([]string, error) {
+	return LookupReverse(ctx, r.IP.String())
+}
@@ -0,0 +1,48 @@
+package pubip
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestLookupReverse(t *testing.T) {
+	server := newDNSServer(t, nil, "", "host-203-0-113-7.example.net.", "customer.example.net.")
+	defer func(r *net.Resolver) { reverseResolver = r }(reverseResolver)
+	reverseResolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp4", server)
+		},
+	}
+
+	expected := []string{"host-203-0-113-7.example.net.", "customer.example.net."}
+	names, err := LookupReverse(context.Background(), "203.0.113.7")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("%v(actual) != %v(expected)", names, expected)
+	}
+
+	r := Result{IP: net.ParseIP("203.0.113.7")}
+	names, err = r.LookupReverse(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("%v(actual) != %v(expected)", names, expected)
+	}
+
+	if _, err := LookupReverse(context.Background(), "not an IP"); err == nil {
+		t.Error("Expected an error for an invalid address")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := LookupReverse(ctx, "203.0.113.7"); err == nil {
+		t.Error("Expected an error for a canceled context")
+	}
+}
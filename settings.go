@@ -1,6 +1,9 @@
 package pubip
 
-import "time"
+import (
+	"net/http"
+	"time"
+)
 
 // Version indicates the version of this package.
 const Version = "1.0.0"
@@ -26,5 +29,44 @@ var APIURIs = []string{
 	"https://shtuff.it/myip/short",
 }
 
+// Sources is the other services, such as the ones answering in JSON or over
+// DNS, queried along with APIURIs.
+var Sources []Source
+
+// Quorum is the minimum amount of identical results required from the
+// services. It is clamped to the amount of services in APIURIs.
+var Quorum = 3
+
+// UserAgent is the User-Agent header sent to the services. Some of them
+// reject requests carrying the default one of Go.
+var UserAgent = "go-pubip/" + Version
+
+// RequestTimeout is the time limit of each request to a service, so that a
+// stalled service still leaves room for retries before Timeout.
+var RequestTimeout = time.Second
+
+// MaxRedirects is the maximum amount of redirects followed for a request to a
+// service, so that a service cannot send the lookups to arbitrary hosts or
+// into a loop.
+var MaxRedirects = 3
+
+// MaxBodySize is the maximum size in bytes of the answer of a service. An IP
+// address is at most 45 characters long, so larger answers are rejected.
+var MaxBodySize int64 = 512
+
 // Timeout sets the time limit of collecting results from different services.
 var Timeout = 2 * time.Second
+
+// HTTPClient is the client used to query the services. Replace it to set a
+// proxy, TLS settings or another timeout. Its Timeout bounds each request,
+// from connecting to reading the whole body, so that a service which accepts
+// the connection but never answers cannot hold a lookup longer than that. Its
+// transport honors the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+// variables, and keeps the connections alive, over HTTP/2 when the services
+// support it, so that the next lookups reuse them. It gives up connecting, the
+// TLS handshake and waiting for the headers of the answer after 5 seconds
+// each.
+var HTTPClient = &http.Client{
+	Transport: newTransport(),
+	Timeout:   10 * time.Second,
+}
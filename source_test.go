@@ -0,0 +1,300 @@
+package pubip
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseJSON(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		err      bool
+	}{
+		{`{"ip":"203.0.113.1"}`, "203.0.113.1", false},
+		{`{"ip":"2001:db8::1","country":"BE"}`, "2001:db8::1", false},
+		{`{"origin":"203.0.113.1"}`, "", true},
+		{`203.0.113.1`, "", true},
+	}
+	for i, v := range tests {
+		actual, err := ParseJSON([]byte(v.input))
+		if (err != nil) != v.err {
+			t.Errorf("Error on case %d: unexpected error %v", i, err)
+		}
+		if actual != v.expected {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, actual, v.expected)
+		}
+	}
+}
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		input    string
+		expected string
+		err      bool
+	}{
+		{"origin", `{"origin":"203.0.113.1"}`, "203.0.113.1", false},
+		{"data.ip", `{"data":{"ip":"2001:db8::1"},"ip":"203.0.113.1"}`, "2001:db8::1", false},
+		{"data.ip", `{"data":"203.0.113.1"}`, "", true},
+		{"data.ip", `{"data":{"ip":1}}`, "", true},
+		{"data.ip", `{"ip":"203.0.113.1"}`, "", true},
+	}
+	for i, v := range tests {
+		actual, err := ParseJSONPath(v.path)([]byte(v.input))
+		if (err != nil) != v.err {
+			t.Errorf("Error on case %d: unexpected error %v", i, err)
+		}
+		if actual != v.expected {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, actual, v.expected)
+		}
+	}
+}
+
+func TestHTTPSources(t *testing.T) {
+	text := newIPServer("203.0.113.1")
+	defer text.Close()
+	js := newIPServer(`{"ip":"203.0.113.1"}`)
+	defer js.Close()
+
+	ip, err := Get(
+		WithSources(text.URL),
+		WithExtraSources(HTTPSource{URL: js.URL, Parse: ParseJSON}),
+		WithQuorum(2),
+		WithTimeout(100*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := net.ParseIP("203.0.113.1"); !ip.Equal(expected) {
+		t.Errorf("%s(actual) != %s(expected)", ip, expected)
+	}
+}
+
+// staticSource is a Source always answering ip.
+type staticSource string
+
+func (s staticSource) Lookup(ctx context.Context) (net.IP, error) {
+	return net.ParseIP(string(s)), nil
+}
+
+func (s staticSource) String() string {
+	return "static://" + string(s)
+}
+
+func TestCustomSources(t *testing.T) {
+	srv := newIPServer("203.0.113.1")
+	defer srv.Close()
+
+	r, err := GetDetailed(
+		WithSources(),
+		WithExtraSources(staticSource("203.0.113.1"), HTTPSource{URL: srv.URL}),
+		WithQuorum(2),
+		WithTimeout(100*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := net.ParseIP("203.0.113.1"); !r.IP.Equal(expected) {
+		t.Errorf("%s(actual) != %s(expected)", r.IP, expected)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer srv.Close()
+
+	withUserinfo := strings.Replace(srv.URL, "://", "://user:secret@", 1)
+	tests := []struct {
+		source HTTPSource
+		name   string
+		ok     bool
+	}{
+		{HTTPSource{URL: srv.URL}, srv.URL, false},
+		{HTTPSource{URL: withUserinfo}, strings.Replace(srv.URL, "://", "://user:xxxxx@", 1), true},
+		{HTTPSource{URL: srv.URL, Username: "user", Password: "secret"}, srv.URL, true},
+		{HTTPSource{URL: withUserinfo, Username: "user", Password: "wrong"}, strings.Replace(srv.URL, "://", "://user:xxxxx@", 1), false},
+	}
+	for i, v := range tests {
+		if name := v.source.String(); name != v.name {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, name, v.name)
+		}
+		_, err := v.source.Lookup(context.Background())
+		if (err == nil) != v.ok {
+			t.Errorf("Error on case %d: %v(actual) != %t(expected)", i, err, v.ok)
+		}
+		if err != nil && strings.Contains(err.Error(), "secret") {
+			t.Errorf("Error on case %d: password in the error: %s", i, err)
+		}
+	}
+}
+
+func TestSourceTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("203.0.113.1"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		source         HTTPSource
+		requestTimeout time.Duration
+		ok             bool
+	}{
+		{HTTPSource{URL: srv.URL}, 50 * time.Millisecond, false},
+		{HTTPSource{URL: srv.URL, Timeout: time.Second}, 50 * time.Millisecond, true},
+		{HTTPSource{URL: srv.URL}, time.Second, true},
+		{HTTPSource{URL: srv.URL, Timeout: 50 * time.Millisecond}, time.Second, false},
+	}
+	for i, v := range tests {
+		_, err := GetDetailed(
+			WithSources(),
+			WithExtraSources(v.source),
+			WithRequestTimeout(v.requestTimeout),
+			WithMaxTries(1),
+			WithTimeout(500*time.Millisecond),
+		)
+		if (err == nil) != v.ok {
+			t.Errorf("Error on case %d: %v(actual) != %t(expected)", i, err, v.ok)
+		}
+	}
+}
+
+func TestHTTPSourceMethod(t *testing.T) {
+	var retries int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodGet && len(body) == 0:
+			w.Write([]byte("203.0.113.1"))
+		case r.Method == http.MethodPost && string(body) == "format=text":
+			// The first try fails, so that the body is sent again.
+			if r.URL.Path == "/retry" && atomic.AddInt32(&retries, 1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("203.0.113.2"))
+		case r.Method == "PROPFIND":
+			w.Write([]byte("not an IP"))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		source   HTTPSource
+		expected string
+	}{
+		{HTTPSource{URL: srv.URL}, "203.0.113.1"},
+		{HTTPSource{URL: srv.URL, Method: http.MethodPost, Body: "format=text"}, "203.0.113.2"},
+		{HTTPSource{URL: srv.URL + "/retry", Method: http.MethodPost, Body: "format=text"}, "203.0.113.2"},
+		{HTTPSource{URL: srv.URL, Method: http.MethodPost}, ""},
+		{HTTPSource{URL: srv.URL, Method: "PROPFIND"}, ""},
+	}
+	for i, v := range tests {
+		ip, err := v.source.Lookup(context.Background())
+		if v.expected == "" {
+			if err == nil {
+				t.Errorf("Error on case %d: expected an error, got %s", i, ip)
+			}
+			continue
+		}
+		if err != nil || ip.String() != v.expected {
+			t.Errorf("Error on case %d: %s, %v(actual) != %s(expected)", i, ip, err, v.expected)
+		}
+	}
+}
+
+func TestUnixSocketSource(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "metadata.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("Unix sockets not supported: %s", err)
+	}
+	var path atomic.Value
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path.Store(r.URL.RequestURI())
+		w.Write([]byte("203.0.113.1"))
+	}))
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	tests := []struct {
+		uri      string
+		expected string
+	}{
+		{"unix://" + socket, "/"},
+		{"unix://" + socket + ":/v1/public-ip", "/v1/public-ip"},
+		{"unix://" + socket + ":/ip?format=text", "/ip?format=text"},
+	}
+	for i, v := range tests {
+		if err := checkURL(v.uri); err != nil {
+			t.Errorf("Error on case %d: unexpected error %s", i, err)
+		}
+		ip, err := Get(WithSources(v.uri), WithQuorum(1), WithTimeout(time.Second))
+		if err != nil {
+			t.Errorf("Error on case %d: unexpected error %s", i, err)
+			continue
+		}
+		if ip.String() != "203.0.113.1" {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, ip, "203.0.113.1")
+		}
+		if p := path.Load().(string); p != v.expected {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, p, v.expected)
+		}
+	}
+}
+
+func TestResponseHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/header":
+			w.Header().Set("X-Your-IP", "203.0.113.7")
+		case "/forwarded":
+			w.Header().Set("X-Forwarded-For", "203.0.113.8, 10.0.0.1")
+			w.Write([]byte("<html>" + strings.Repeat("x", 1024) + "</html>"))
+		default:
+			w.Write([]byte("203.0.113.9"))
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		source   HTTPSource
+		expected string
+		err      string
+	}{
+		{HTTPSource{URL: srv.URL + "/header", ResponseHeader: "X-Your-IP"}, "203.0.113.7", ""},
+		{HTTPSource{URL: srv.URL + "/forwarded", ResponseHeader: "x-forwarded-for"}, "203.0.113.8", ""},
+		{HTTPSource{URL: srv.URL + "/body", ResponseHeader: "X-Your-IP"}, "", "No X-Your-IP header in the answer of " + srv.URL + "/body"},
+		{HTTPSource{URL: srv.URL + "/body"}, "203.0.113.9", ""},
+	}
+	for i, v := range tests {
+		ip, err := v.source.Lookup(context.Background())
+		if err != nil {
+			if err.Error() != v.err {
+				t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, err, v.err)
+			}
+			continue
+		}
+		if ip.String() != v.expected {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, ip, v.expected)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package pubip
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultSTUNServer is the server queried by a STUNSource without Server.
+const DefaultSTUNServer = "stun.l.google.com:19302"
+
+const (
+	stunMagicCookie      = 0x2112A442
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunMappedAddress    = 0x0001
+	stunXORMappedAddress = 0x0020
+	stunHeaderLen        = 20
+)
+
+// STUNSource is a STUN server (RFC 5389) answering the address of the
+// querier to a Binding Request. It's useful on networks where UDP is allowed
+// but HTTP to arbitrary services is not.
+//
+// Usage:
+//
+//		pubip.Sources = append(pubip.Sources, pubip.STUNSource{})
+type STUNSource struct {
+	// Server is the address of the STUN server, including the port.
+	// DefaultSTUNServer is used when empty.
+	Server string
+	// Timeout is the time limit of the transaction, retransmissions included,
+	// when the context has no earlier deadline. 5 seconds when zero. It
+	// replaces the RequestTimeout of the Client when not zero.
+	Timeout time.Duration
+}
+
+// Lookup sends a Binding Request to the server.
+func (s STUNSource) Lookup(ctx context.Context) (net.IP, error) {
+	return s.lookup(ctx, anyFamily, NewClient().dial())
+}
+
+func (s STUNSource) String() string {
+	return "stun://" + s.server()
+}
+
+func (s STUNSource) server() string {
+	if s.Server == "" {
+		return DefaultSTUNServer
+	}
+	return s.Server
+}
+
+// lookup sends a Binding Request over the network of the family, connecting
+// with dial, and retransmits it with a doubling interval until an answer or
+// the deadline.
+func (s STUNSource) lookup(ctx context.Context, f Family, dial dialFunc) (net.IP, error) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := dial(ctx, f.network("udp"), s.server())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.SetDeadline(time.Now())
+	}()
+
+	req := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	txID := req[8:stunHeaderLen]
+	if _, err := rand.Read(txID); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1024)
+	for rto := 500 * time.Millisecond; ; rto *= 2 {
+		if _, err := conn.Write(req); err != nil {
+			return nil, stunErr(ctx, err)
+		}
+		conn.SetReadDeadline(time.Now().Add(rto))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() && ctx.Err() == nil {
+					break
+				}
+				return nil, stunErr(ctx, err)
+			}
+			ip, err := parseSTUNResponse(buf[:n], txID)
+			if err == errSTUNUnrelated {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			return ip, nil
+		}
+	}
+}
+
+// stunErr prefers the error of the context, which explains why a read or a
+// write failed once it is done.
+func stunErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+var errSTUNUnrelated = errors.New("STUN message unrelated to the transaction")
+
+// parseSTUNResponse extracts the mapped address of a Binding success response
+// to the transaction.
+func parseSTUNResponse(msg, txID []byte) (net.IP, error) {
+	if len(msg) < stunHeaderLen ||
+		binary.BigEndian.Uint32(msg[4:]) != stunMagicCookie ||
+		string(msg[8:stunHeaderLen]) != string(txID) {
+		return nil, errSTUNUnrelated
+	}
+	if t := binary.BigEndian.Uint16(msg[0:]); t != stunBindingSuccess {
+		return nil, fmt.Errorf("STUN Binding Request failed with message type %#04x", t)
+	}
+	attrs := msg[stunHeaderLen:]
+	if l := int(binary.BigEndian.Uint16(msg[2:])); l < len(attrs) {
+		attrs = attrs[:l]
+	}
+
+	var mapped net.IP
+	for len(attrs) >= 4 {
+		t := binary.BigEndian.Uint16(attrs[0:])
+		l := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+l > len(attrs) {
+			break
+		}
+		v := attrs[4 : 4+l]
+		switch t {
+		case stunXORMappedAddress:
+			return decodeSTUNAddress(v, msg[4:stunHeaderLen])
+		case stunMappedAddress:
+			mapped, _ = decodeSTUNAddress(v, nil)
+		}
+		// Attributes are padded to a multiple of 4 bytes, which a last one
+		// may lack.
+		next := 4 + (l+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	if mapped != nil {
+		return mapped, nil
+	}
+	return nil, errors.New("No mapped address in the STUN response")
+}
+
+// decodeSTUNAddress decodes the value of a MAPPED-ADDRESS attribute, or of a
+// XOR-MAPPED-ADDRESS one when key, the magic cookie followed by the
+// transaction ID, is given.
+func decodeSTUNAddress(v, key []byte) (net.IP, error) {
+	if len(v) < 4 {
+		return nil, errors.New("STUN address attribute too short")
+	}
+	var n int
+	switch v[1] {
+	case 0x01:
+		n = net.IPv4len
+	case 0x02:
+		n = net.IPv6len
+	default:
+		return nil, fmt.Errorf("Unknown STUN address family %#02x", v[1])
+	}
+	if len(v) < 4+n {
+		return nil, errors.New("STUN address attribute too short")
+	}
+	ip := make(net.IP, n)
+	copy(ip, v[4:4+n])
+	for i := range key {
+		if i < n {
+			ip[i] ^= key[i]
+		}
+	}
+	return ip, nil
+}
@@ -0,0 +1,133 @@
+package pubip
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// stunResponse builds a Binding success response to req carrying ip in a
+// XOR-MAPPED-ADDRESS attribute.
+func stunResponse(req []byte, ip net.IP) []byte {
+	family, addr := byte(0x01), ip.To4()
+	if addr == nil {
+		family, addr = 0x02, ip.To16()
+	}
+	key := req[4:stunHeaderLen]
+	v := []byte{0, family, 0x21 ^ 0x12, 0x34 ^ 0x12}
+	for i, b := range addr {
+		v = append(v, b^key[i])
+	}
+
+	resp := make([]byte, stunHeaderLen, stunHeaderLen+4+len(v))
+	copy(resp, req)
+	binary.BigEndian.PutUint16(resp[0:], stunBindingSuccess)
+	binary.BigEndian.PutUint16(resp[2:], uint16(4+len(v)))
+	resp = binary.BigEndian.AppendUint16(resp, stunXORMappedAddress)
+	resp = binary.BigEndian.AppendUint16(resp, uint16(len(v)))
+	return append(resp, v...)
+}
+
+func TestParseSTUNResponse(t *testing.T) {
+	req := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	copy(req[8:], "0123456789ab")
+
+	tests := []net.IP{
+		net.ParseIP("203.0.113.1"),
+		net.ParseIP("2001:db8::1"),
+	}
+	for i, expected := range tests {
+		actual, err := parseSTUNResponse(stunResponse(req, expected), req[8:])
+		if err != nil {
+			t.Errorf("Error on case %d: %s", i, err)
+			continue
+		}
+		if !actual.Equal(expected) {
+			t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, actual, expected)
+		}
+	}
+
+	other := stunResponse(req, tests[0])
+	copy(other[8:], "ba9876543210")
+	if _, err := parseSTUNResponse(other, req[8:]); err != errSTUNUnrelated {
+		t.Errorf("Expected a response to another transaction to be ignored, got %v", err)
+	}
+}
+
+func TestParseSTUNResponseUnpadded(t *testing.T) {
+	req := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	copy(req[8:], "0123456789ab")
+
+	expected := net.ParseIP("203.0.113.1")
+	resp := make([]byte, stunHeaderLen)
+	copy(resp, req)
+	binary.BigEndian.PutUint16(resp[0:], stunBindingSuccess)
+	resp = binary.BigEndian.AppendUint16(resp, stunMappedAddress)
+	resp = binary.BigEndian.AppendUint16(resp, 8)
+	resp = append(resp, 0, 0x01, 0x12, 0x34)
+	resp = append(resp, expected.To4()...)
+	// A SOFTWARE attribute of 5 bytes, without its padding.
+	resp = binary.BigEndian.AppendUint16(resp, 0x8022)
+	resp = binary.BigEndian.AppendUint16(resp, 5)
+	resp = append(resp, "pubip"...)
+	binary.BigEndian.PutUint16(resp[2:], uint16(len(resp)-stunHeaderLen))
+
+	actual, err := parseSTUNResponse(resp, req[8:])
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !actual.Equal(expected) {
+		t.Errorf("%s(actual) != %s(expected)", actual, expected)
+	}
+}
+
+func TestSTUNSource(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	expected := net.ParseIP("203.0.113.1")
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n >= stunHeaderLen {
+				conn.WriteTo(stunResponse(buf[:n], expected), addr)
+			}
+		}
+	}()
+
+	s := STUNSource{Server: conn.LocalAddr().String()}
+	actual, err := s.Lookup(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !actual.Equal(expected) {
+		t.Errorf("%s(actual) != %s(expected)", actual, expected)
+	}
+}
+
+func TestSTUNSourceTimeout(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := STUNSource{Server: conn.LocalAddr().String(), Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	if _, err := s.Lookup(context.Background()); err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Lookup took %s despite a timeout of %s", d, s.Timeout)
+	}
+}
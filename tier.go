@@ -0,0 +1,43 @@
+package pubip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// GetTiered is like Get but queries the services tier by tier, such as
+// self-hosted ones before public ones, and returns the address agreed on by
+// the first tier which reaches a consensus. The next tiers are only queried
+// when the previous ones fail.
+func GetTiered(tiers [][]string, opts ...Option) (net.IP, error) {
+	return NewClient(opts...).GetTiered(tiers)
+}
+
+// GetTiered is like Get but queries the services of the tiers instead of
+// APIURIs and Sources, tier by tier, and returns the address agreed on by the
+// first tier which reaches a consensus. The next tiers are only queried when
+// the previous ones fail. If all of them fail, the error is an
+// `*AggregateError` holding the failures and the answers of all the tiers.
+// Other failures, such as the rate limit, are returned at once.
+func (c *Client) GetTiered(tiers [][]string) (net.IP, error) {
+	all := &AggregateError{Err: fmt.Errorf("No consensus in any of the %d tiers", len(tiers))}
+	for i, uris := range tiers {
+		tier := *c
+		tier.APIURIs = uris
+		tier.Sources = nil
+		r, err := tier.get(context.Background(), anyFamily)
+		if err == nil {
+			return r.IP, nil
+		}
+		var aggErr *AggregateError
+		if !errors.As(err, &aggErr) {
+			return nil, err
+		}
+		c.warnf("Falling back after tier %d: %s", i+1, aggErr.Err)
+		all.Errors = append(all.Errors, aggErr.Errors...)
+		all.Results = append(all.Results, aggErr.Results...)
+	}
+	return nil, all
+}
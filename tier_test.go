@@ -0,0 +1,47 @@
+package pubip
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetTiered(t *testing.T) {
+	private := newIPServer("203.0.113.1")
+	defer private.Close()
+	public := newIPServer("203.0.113.2")
+	defer public.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	defer down.Close()
+
+	tests := []struct {
+		tiers    [][]string
+		expected string
+		errors   int
+	}{
+		{[][]string{{private.URL, private.URL + "/other"}, {public.URL, public.URL + "/other"}}, "203.0.113.1", 0},
+		{[][]string{{down.URL, private.URL}, {public.URL, public.URL + "/other"}}, "203.0.113.2", 0},
+		{[][]string{{down.URL, private.URL}, {down.URL + "/other", public.URL}}, "", 2},
+	}
+	for i, v := range tests {
+		ip, err := GetTiered(v.tiers, WithQuorum(2), WithMaxTries(1), WithTimeout(100*time.Millisecond))
+		if v.errors == 0 {
+			if err != nil {
+				t.Errorf("Error on case %d: unexpected error %s", i, err)
+			} else if ip.String() != v.expected {
+				t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, ip, v.expected)
+			}
+			continue
+		}
+		var aggErr *AggregateError
+		if !errors.As(err, &aggErr) {
+			t.Errorf("Error on case %d: expected an *AggregateError, got %T: %v", i, err, err)
+			continue
+		}
+		if len(aggErr.Errors) != v.errors || len(aggErr.Results) != 2 {
+			t.Errorf("Error on case %d: unexpected failures %v and results %v", i, aggErr.Errors, aggErr.Results)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package pubip
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSpans(t *testing.T) {
+	good := newIPServer("203.0.113.1")
+	defer good.Close()
+	bad := newIPServer("not an IP")
+	defer bad.Close()
+
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	ctx, root := tp.Tracer("test").Start(context.Background(), "root")
+	if _, err := GetContext(ctx, WithSources(good.URL, bad.URL), WithQuorum(1), WithTimeout(100*time.Millisecond)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	root.End()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range sr.Ended() {
+		name := s.Name()
+		for _, a := range s.Attributes() {
+			if a.Key == "pubip.source" {
+				name = a.Value.AsString()
+			}
+		}
+		spans[name] = s
+	}
+	get := spans["pubip.Get"]
+	if get == nil || get.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Fatal("Expected a pubip.Get span child of the root one")
+	}
+	if !hasAttribute(get, attribute.Bool("pubip.quorum_reached", true)) {
+		t.Errorf("Unexpected attributes of pubip.Get: %v", get.Attributes())
+	}
+	for _, u := range []string{good.URL, bad.URL} {
+		s := spans[u]
+		if s == nil || s.Parent().SpanID() != get.SpanContext().SpanID() {
+			t.Errorf("Expected a span of %s child of pubip.Get", u)
+			continue
+		}
+		if !hasAttribute(s, attribute.Int("http.response.status_code", 200)) {
+			t.Errorf("Unexpected attributes of %s: %v", u, s.Attributes())
+		}
+	}
+	if spans[bad.URL] != nil && len(spans[bad.URL].Events()) == 0 {
+		t.Errorf("Expected the error of %s to be recorded", bad.URL)
+	}
+}
+
+func hasAttribute(s sdktrace.ReadOnlySpan, kv attribute.KeyValue) bool {
+	for _, a := range s.Attributes() {
+		if a == kv {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package pubip
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// watchInterval is the interval of Watch and OnChange when the one given is
+// not positive.
+const watchInterval = time.Minute
+
+// Watch looks up the public IP address every interval and sends it when it
+// differs from the last one, starting with the first one found. The errors of
+// the lookups are sent on the second channel. Both channels are closed once
+// ctx is done.
+func Watch(ctx context.Context, interval time.Duration, opts ...Option) (<-chan string, <-chan error) {
+	return NewClient(opts...).Watch(ctx, interval)
+}
+
+// Watch looks up the public IP address every interval and sends it when it
+// differs from the last one, starting with the first one found. The errors of
+// the lookups are sent on the second channel, which holds one of them until
+// it's read and drops the next ones meanwhile, so that it doesn't need to be
+// read. Both channels are closed once ctx is done or the Client is closed. A new address is only sent once
+// StablePolls consecutive lookups found it. With WatchNetwork, the address is
+// also looked up, ignoring the cache, shortly after the network changes. An
+// interval of zero or less is replaced by a minute.
+func (c *Client) Watch(ctx context.Context, interval time.Duration) (<-chan string, <-chan error) {
+	if interval <= 0 {
+		interval = watchInterval
+	}
+	ips := make(chan string)
+	// An error waits for a single reader, so that the addresses keep coming
+	// to the callers which don't read the errors.
+	errs := make(chan error, 1)
+	ctx, cancel := c.closable(ctx)
+	go func() {
+		defer cancel()
+		defer close(ips)
+		defer close(errs)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		var changes <-chan struct{}
+		if c.WatchNetwork {
+			changes = networkChanges(ctx)
+		}
+		var last, candidate string
+		var seen int
+		var changed bool
+		for {
+			var ip net.IP
+			var err error
+			if changed {
+				var r Result
+				r, err = c.refresh(ctx, anyFamily, c.flightKey(anyFamily))
+				ip = r.IP
+			} else {
+				ip, err = c.GetContext(ctx)
+			}
+			changed = false
+			if ctx.Err() != nil {
+				return
+			}
+			switch {
+			case err != nil:
+				select {
+				case errs <- err:
+				default:
+				}
+			case ip.String() == last:
+				// The address bounced back.
+				candidate, seen = "", 0
+			default:
+				if ip.String() != candidate {
+					candidate, seen = ip.String(), 0
+				}
+				seen++
+				if last != "" && seen < c.StablePolls {
+					break
+				}
+				last, candidate, seen = candidate, "", 0
+				select {
+				case ips <- last:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-t.C:
+			case <-changes:
+				if sleep(ctx, networkSettle) != nil {
+					return
+				}
+				// The burst of changes is handled by a single lookup.
+				select {
+				case <-changes:
+				default:
+				}
+				changed = true
+				t.Reset(interval)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ips, errs
+}
+
+// OnChange calls fn with the previous and the new public IP address whenever
+// it changes, looking it up every interval. The first address found is passed
+// with an empty previous one. The errors of the lookups are ignored. OnChange
+// returns at once, and stops looking up once ctx is done.
+func OnChange(ctx context.Context, interval time.Duration, fn func(old, new string), opts ...Option) {
+	NewClient(opts...).OnChange(ctx, interval, fn)
+}
+
+// OnChange calls fn with the previous and the new public IP address whenever
+// it changes, looking it up every interval. The first address found is passed
+// with an empty previous one. The errors of the lookups are ignored. OnChange
+// returns at once, and stops looking up once ctx is done or the Client is
+// closed. With WatchNetwork, the address is also looked up shortly after the
+// network changes. An interval of zero or less is replaced by a minute.
+func (c *Client) OnChange(ctx context.Context, interval time.Duration, fn func(old, new string)) {
+	ips, errs := c.Watch(ctx, interval)
+	go func() {
+		var last string
+		for ips != nil || errs != nil {
+			select {
+			case ip, ok := <-ips:
+				if !ok {
+					ips = nil
+					continue
+				}
+				fn(last, ip)
+				last = ip
+			case _, ok := <-errs:
+				if !ok {
+					errs = nil
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,162 @@
+package pubip
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	var answer atomic.Value
+	answer.Store("203.0.113.1")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(answer.Load().(string)))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ips, errs := Watch(ctx, 10*time.Millisecond, WithSources(srv.URL), WithTimeout(20*time.Millisecond))
+
+	tests := []string{"203.0.113.1", "203.0.113.2", "not an IP", "203.0.113.3"}
+	for i, v := range tests {
+		answer.Store(v)
+		select {
+		case actual := <-ips:
+			if actual != v {
+				t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, actual, v)
+			}
+		case err := <-errs:
+			if v != "not an IP" {
+				t.Errorf("Error on case %d: %s", i, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Error on case %d: nothing received", i)
+		}
+	}
+
+	cancel()
+	for ips != nil || errs != nil {
+		select {
+		case _, ok := <-ips:
+			if !ok {
+				ips = nil
+			}
+		case _, ok := <-errs:
+			if !ok {
+				errs = nil
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected the channels to be closed")
+		}
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	var answer atomic.Value
+	answer.Store("203.0.113.1")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(answer.Load().(string)))
+	}))
+	defer srv.Close()
+
+	type change struct{ old, new string }
+	changes := make(chan change)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	OnChange(ctx, 10*time.Millisecond, func(old, new string) {
+		changes <- change{old, new}
+	}, WithSources(srv.URL), WithTimeout(20*time.Millisecond))
+
+	tests := []change{{"", "203.0.113.1"}, {"203.0.113.1", "203.0.113.2"}}
+	for i, expected := range tests {
+		answer.Store(expected.new)
+		select {
+		case actual := <-changes:
+			if actual != expected {
+				t.Errorf("Error on case %d: %v(actual) != %v(expected)", i, actual, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Error on case %d: fn not called", i)
+		}
+	}
+}
+
+func TestWatchStablePolls(t *testing.T) {
+	var mu sync.Mutex
+	answers := []string{"203.0.113.1", "203.0.113.2", "203.0.113.1", "203.0.113.2", "203.0.113.2", "203.0.113.3", "203.0.113.2"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		answer := answers[0]
+		if len(answers) > 1 {
+			answers = answers[1:]
+		}
+		mu.Unlock()
+		w.Write([]byte(answer))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ips, _ := Watch(ctx, 10*time.Millisecond, WithSources(srv.URL), WithStablePolls(2))
+	var actual []string
+	for timeout := time.After(300 * time.Millisecond); ; {
+		select {
+		case ip := <-ips:
+			actual = append(actual, ip)
+			continue
+		case <-timeout:
+		}
+		break
+	}
+	if expected := []string{"203.0.113.1", "203.0.113.2"}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%v(actual) != %v(expected)", actual, expected)
+	}
+}
+
+func TestWatchNonPositiveInterval(t *testing.T) {
+	srv := newIPServer("203.0.113.1")
+	defer srv.Close()
+
+	for i, interval := range []time.Duration{0, -time.Second} {
+		ctx, cancel := context.WithCancel(context.Background())
+		ips, _ := Watch(ctx, interval, WithSources(srv.URL), WithQuorum(1))
+		select {
+		case actual := <-ips:
+			if actual != "203.0.113.1" {
+				t.Errorf("Error on case %d: %s(actual) != %s(expected)", i, actual, "203.0.113.1")
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Error on case %d: nothing received", i)
+		}
+		cancel()
+	}
+}
+
+func TestWatchWithoutReadingErrors(t *testing.T) {
+	var answer atomic.Value
+	answer.Store("not an IP")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(answer.Load().(string)))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ips, _ := Watch(ctx, 10*time.Millisecond, WithSources(srv.URL), WithTimeout(20*time.Millisecond))
+	// Several lookups fail before an address is found.
+	time.Sleep(100 * time.Millisecond)
+	answer.Store("203.0.113.1")
+	select {
+	case actual := <-ips:
+		if actual != "203.0.113.1" {
+			t.Errorf("%s(actual) != %s(expected)", actual, "203.0.113.1")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the address although the errors are not read")
+	}
+}